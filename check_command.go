@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+	"github.com/spacelift-io/prometheus-exporter/client/session"
+	"github.com/spacelift-io/prometheus-exporter/logging"
+)
+
+// checkResult is the outcome of a single step of the check command.
+type checkResult struct {
+	account string
+	step    string
+	status  string
+	details string
+
+	// exitCode is the exit code the check command uses if this is the worst result.
+	exitCode int
+}
+
+var checkCommand *cli.Command = &cli.Command{
+	Name: "check",
+	Usage: "Checks that the exporter can reach the Spacelift API with the given settings, by exchanging the API key " +
+		"and running every enabled collector once",
+	Flags:                  serveCommand.Flags,
+	MutuallyExclusiveFlags: serveCommand.MutuallyExclusiveFlags,
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			return cli.Exit(err.Error(), ExitCodeStartupError)
+		}
+
+		ctx = logging.Init(ctx, cfg.IsDevelopment)
+
+		if len(cfg.accounts()) == 0 {
+			return cli.Exit("there are no accounts to check, the configuration only serves probes", ExitCodeStartupError)
+		}
+
+		var results []*checkResult
+		for _, account := range cfg.accounts() {
+			results = append(results, checkAccount(ctx, cfg, account)...)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if len(cfg.Accounts) > 0 {
+			fmt.Fprintln(writer, "ACCOUNT\tCHECK\tSTATUS\tDETAILS")
+		} else {
+			fmt.Fprintln(writer, "CHECK\tSTATUS\tDETAILS")
+		}
+
+		exitCode := ExitCodeSuccess
+		for _, result := range results {
+			if len(cfg.Accounts) > 0 {
+				fmt.Fprintf(writer, "%s\t", result.account)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", result.step, result.status, result.details)
+
+			exitCode = max(exitCode, result.exitCode)
+		}
+
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+
+		if exitCode != ExitCodeSuccess {
+			return cli.Exit("", exitCode)
+		}
+
+		return nil
+	},
+}
+
+// checkAccount exchanges the account's API key, then runs every enabled
+// sub-collector on its own so that each failure is reported separately.
+func checkAccount(ctx context.Context, cfg *config, account *accountConfig) []*checkResult {
+	sessionResult := &checkResult{account: account.Name, step: "session", status: "ok", details: account.APIEndpoint}
+
+	secretProvider, err := buildSecretProvider(account.APIKeySecret, account.APIKeySecretFile)
+	if err != nil {
+		return []*checkResult{sessionResult.fail(err.Error(), ExitCodeSessionError)}
+	}
+
+	httpClient, err := newHTTPClient(account.CACertPath)
+	if err != nil {
+		return []*checkResult{sessionResult.fail(err.Error(), ExitCodeSessionError)}
+	}
+
+	sessionCtx, cancel := context.WithTimeout(ctx, cfg.ScrapeTimeout)
+	defer cancel()
+
+	accountSession, err := session.NewWithSecretProvider(sessionCtx, httpClient, account.APIEndpoint, account.APIKeyID, secretProvider)
+	if err != nil {
+		return []*checkResult{sessionResult.fail(err.Error(), ExitCodeSessionError)}
+	}
+
+	results := []*checkResult{sessionResult}
+	api := client.NewWithRetryPolicy(httpClient, accountSession, cfg.Retry.policy())
+
+	billing := cfg.Billing
+	if account.Billing != nil {
+		billing = *account.Billing
+	}
+
+	for _, name := range cfg.collectorNames {
+		results = append(results, checkSubCollector(ctx, cfg, api, name, billing, account.Name))
+	}
+
+	return results
+}
+
+func checkSubCollector(ctx context.Context, cfg *config, api client.Client, name string, billing billingConfig, account string) *checkResult {
+	result := &checkResult{account: account, step: name, status: "ok"}
+
+	collector := subCollectors[name].factory()
+	if configurable, ok := collector.(configurableSubCollector); ok {
+		configurable.configure(collectorOptions{billing: billing})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ScrapeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	metrics, err := collector.Collect(ctx, api)
+	duration := time.Since(start).Round(time.Millisecond)
+
+	var partial *client.PartialError
+	switch {
+	case errors.As(err, &partial):
+		fields := make([]string, 0, len(partial.Fields))
+		for _, field := range partial.Fields {
+			fields = append(fields, checkFailure(name, field.Path, field.Message, &field))
+		}
+
+		// A query that only asks for the field that failed returns no metrics at all.
+		status := "partial"
+		if len(metrics) == 0 {
+			status = "failed"
+		}
+
+		result.status = status
+		result.details = strings.Join(fields, "; ")
+		result.exitCode = ExitCodeCollectorError
+	case err != nil:
+		message := err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			message = fmt.Sprintf("timed out after %s, try a longer --scrape-timeout", cfg.ScrapeTimeout)
+		} else if errors.Is(err, client.ErrUnauthorized) {
+			message = "unauthorized"
+		}
+
+		result.status = "failed"
+		result.details = checkFailure(name, classifyError(err), message, err)
+		result.exitCode = ExitCodeCollectorError
+	default:
+		result.details = fmt.Sprintf("%d metrics in %s", len(metrics), duration)
+	}
+
+	return result
+}
+
+// checkFailure describes a failure, with a hint about the likely cause of failures
+// the API key's permissions are usually responsible for.
+func checkFailure(collector, what, message string, err error) string {
+	failure := fmt.Sprintf("%s: %s", what, message)
+
+	if !permissionFailure(err) {
+		return failure
+	}
+
+	if collector == "usage" {
+		return failure + " - key is not admin"
+	}
+
+	return failure + " - key lacks permission"
+}
+
+// permissionFailure returns true if the Spacelift API rejected the request because of
+// the API key's credentials or permissions.
+func permissionFailure(err error) bool {
+	var httpErr *client.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden
+	}
+
+	return errors.Is(err, client.ErrUnauthorized)
+}
+
+func (r *checkResult) fail(details string, exitCode int) *checkResult {
+	r.status = "failed"
+	r.details = details
+	r.exitCode = exitCode
+
+	return r
+}
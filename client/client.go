@@ -1,12 +1,13 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/hasura/go-graphql-client"
+	"go.uber.org/zap"
 
 	"github.com/spacelift-io/prometheus-exporter/client/session"
 	"github.com/spacelift-io/prometheus-exporter/logging"
@@ -15,35 +16,104 @@ import (
 type client struct {
 	wraps   *http.Client
 	session session.Session
+	retry   RetryPolicy
 }
 
-// New returns a new instance of a Spacelift Client.
+// New returns a new instance of a Spacelift Client that retries transient failures
+// using the DefaultRetryPolicy.
 func New(wraps *http.Client, session session.Session) Client {
-	return &client{wraps: wraps, session: session}
+	return NewWithRetryPolicy(wraps, session, DefaultRetryPolicy())
+}
+
+// NewWithRetryPolicy returns a new instance of a Spacelift Client that retries
+// transient failures according to the policy.
+func NewWithRetryPolicy(wraps *http.Client, session session.Session, retry RetryPolicy) Client {
+	return &client{wraps: wraps, session: session, retry: retry}
 }
 
 func (c *client) Query(ctx context.Context, query interface{}, variables map[string]interface{}) error {
 	logger := logging.FromContext(ctx).Sugar()
-	apiClient, err := c.apiClient(ctx)
-	if err != nil {
+
+	var data []byte
+	var err error
+	refreshedToken := false
+
+	// Only retries of transient failures count as attempts, so that the token is
+	// refreshed whatever the retry policy.
+	attempt := 1
+	for {
+		headers := http.Header{}
+		data, err = c.queryOnce(ctx, query, variables, &headers)
+		if err == nil {
+			break
+		}
+
+		reason, retryable := retryReason(err)
+		if !retryable {
+			break
+		}
+
+		if reason == RetryReasonUnauthorized {
+			// Refreshing the token is the only thing that can fix this, so there's no
+			// point in retrying more than once or in waiting before we do. Nor is there
+			// any point if only some fields were refused because of the key's
+			// permissions.
+			if refreshedToken || !rejected(err, data) {
+				break
+			}
+
+			logger.Warn("Server returned an unauthorized response - retrying request with a new token")
+			c.session.RefreshToken(ctx)
+			refreshedToken = true
+		} else {
+			if attempt >= c.retry.MaxAttempts {
+				break
+			}
+
+			delay := c.retry.delay(attempt, headers)
+			logger.Warnw("Request to the Spacelift API failed - retrying", "reason", reason, "attempt", attempt, "delay", delay, zap.Error(err))
+
+			if !wait(ctx, delay) {
+				break
+			}
+			attempt++
+		}
+
+		if c.retry.OnRetry != nil {
+			c.retry.OnRetry(reason)
+		}
+	}
+
+	// GraphQL can return data for some fields alongside errors for others, so we decode
+	// whatever we got before looking at the errors. A response where every field failed
+	// has nothing to decode.
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) || (err != nil && !hasData(data)) {
 		return err
 	}
 
-	err = apiClient.Query(ctx, query, variables, graphql.OperationName("PrometheusExporter"))
-	if err != nil && strings.Contains(err.Error(), "unauthorized") {
-		logger.Warn("Server returned an unauthorized response - retrying request with a new token")
-		c.session.RefreshToken(ctx)
+	if decodeErr := graphql.UnmarshalGraphQL(data, query); decodeErr != nil {
+		return &GraphQLErrors{Errors: []GraphQLError{{
+			Message:    fmt.Sprintf("could not decode response: %v", decodeErr),
+			Extensions: map[string]any{"code": graphql.ErrGraphQLDecode},
+		}}}
+	}
 
-		// Try again in case refreshing the token fixes the problem
-		apiClient, err = c.apiClient(ctx)
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return asPartialError(err)
+	}
+
+	return nil
+}
 
-		err = apiClient.Query(ctx, query, variables)
+func (c *client) queryOnce(ctx context.Context, query interface{}, variables map[string]interface{}, headers *http.Header) ([]byte, error) {
+	apiClient, err := c.apiClient(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return err
+	data, err := apiClient.QueryRaw(ctx, query, variables, graphql.OperationName("PrometheusExporter"), graphql.BindResponseHeaders(headers))
+
+	return data, wrapError(err)
 }
 
 func (c *client) apiClient(ctx context.Context) (*graphql.Client, error) {
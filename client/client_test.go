@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spacelift-io/prometheus-exporter/logging"
+)
+
+// countingSession counts how many times the token is refreshed.
+type countingSession struct {
+	endpoint  string
+	refreshes int
+}
+
+func (s *countingSession) BearerToken(context.Context) (string, error) {
+	return "token", nil
+}
+
+func (s *countingSession) Endpoint() string {
+	return s.endpoint
+}
+
+func (s *countingSession) RefreshToken(context.Context) error {
+	s.refreshes++
+	return nil
+}
+
+func TestQueryRefreshesTokenOnlyWhenRequestIsRejected(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status  int
+		body    string
+		refresh bool
+		partial bool
+	}{
+		"unauthorized status": {
+			status:  http.StatusUnauthorized,
+			refresh: true,
+		},
+		"forbidden status": {
+			status: http.StatusForbidden,
+		},
+		"top-level error": {
+			status:  http.StatusOK,
+			body:    `{"data":null,"errors":[{"message":"unauthorized"}]}`,
+			refresh: true,
+		},
+		"field error": {
+			status:  http.StatusOK,
+			body:    `{"data":{"name":"acme","usage":null},"errors":[{"message":"unauthorized","path":["usage"],"extensions":{"code":"UNAUTHORIZED"}}]}`,
+			partial: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body)
+			}))
+			t.Cleanup(server.Close)
+
+			session := &countingSession{endpoint: server.URL}
+			api := NewWithRetryPolicy(server.Client(), session, RetryPolicy{MaxAttempts: 1})
+
+			var query struct {
+				Name  string `graphql:"name"`
+				Usage *struct {
+					Minutes int `graphql:"minutes"`
+				} `graphql:"usage"`
+			}
+
+			err := api.Query(logging.Init(context.Background(), true), &query, nil)
+			if err == nil {
+				t.Fatal("query succeeded")
+			}
+
+			if refreshed := session.refreshes > 0; refreshed != tc.refresh {
+				t.Errorf("got %d token refreshes for %v, want refresh %v", session.refreshes, err, tc.refresh)
+			}
+
+			if IsPartial(err) != tc.partial {
+				t.Errorf("got error %v, want partial %v", err, tc.partial)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hasura/go-graphql-client"
+
+	"github.com/spacelift-io/prometheus-exporter/client/session"
+)
+
+// ErrUnauthorized matches, using errors.Is, every error caused by the Spacelift API
+// rejecting the exporter's credentials, including API keys rejected by the session.
+var ErrUnauthorized = session.ErrUnauthorized
+
+// HTTPError is returned when the Spacelift API responds with an unsuccessful HTTP status.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("the Spacelift API responded with HTTP %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Is makes 401 and 403 responses match ErrUnauthorized.
+func (e *HTTPError) Is(target error) bool {
+	return target == ErrUnauthorized && (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
+
+// TransportError is returned when a request never got a response from the Spacelift
+// API, for example because the connection was refused or reset.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("could not reach the Spacelift API: %v", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// GraphQLError is a single entry of the errors array of a GraphQL response.
+type GraphQLError struct {
+	Message    string
+	Path       []any
+	Extensions map[string]any
+}
+
+// GraphQLErrors is returned when the Spacelift API responds with GraphQL errors, or
+// with a response that can't be decoded.
+type GraphQLErrors struct {
+	Errors []GraphQLError
+}
+
+func (e *GraphQLErrors) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, graphqlErr := range e.Errors {
+		if path := fieldPath(graphqlErr.Path); path != "" {
+			messages = append(messages, fmt.Sprintf("%s: %s", path, graphqlErr.Message))
+		} else {
+			messages = append(messages, graphqlErr.Message)
+		}
+	}
+
+	return fmt.Sprintf("the Spacelift API returned errors: %s", strings.Join(messages, "; "))
+}
+
+// Is makes GraphQL errors reporting an authorization failure match ErrUnauthorized.
+func (e *GraphQLErrors) Is(target error) bool {
+	if target != ErrUnauthorized {
+		return false
+	}
+
+	for _, graphqlErr := range e.Errors {
+		if unauthorized, hasCode := isUnauthorized(graphqlErr.Extensions); hasCode {
+			if unauthorized {
+				return true
+			}
+		} else if len(graphqlErr.Path) == 0 && isUnauthorizedMessage(graphqlErr.Message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rejectsRequest returns true if any of the errors is a top-level one reporting an
+// authorization failure, as opposed to one for a single field.
+func (e *GraphQLErrors) rejectsRequest() bool {
+	for _, graphqlErr := range e.Errors {
+		if len(graphqlErr.Path) > 0 {
+			continue
+		}
+
+		if unauthorized, hasCode := isUnauthorized(graphqlErr.Extensions); unauthorized || (!hasCode && isUnauthorizedMessage(graphqlErr.Message)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isUnauthorized returns true if a GraphQL error reports an authorization failure,
+// based on its extensions.code, and whether it has a code at all.
+func isUnauthorized(extensions map[string]any) (unauthorized, hasCode bool) {
+	code, ok := extensions["code"].(string)
+	if !ok {
+		return false, false
+	}
+
+	return code == "UNAUTHORIZED" || code == "UNAUTHENTICATED", true
+}
+
+// isUnauthorizedMessage is the fallback for top-level errors without a code. The
+// Spacelift API reports an invalid or expired token as a GraphQL error with the
+// message "unauthorized" and no extensions, so that message is the only thing we can
+// go by. Field errors are never matched by their message, as the same message there
+// may just mean the key lacks the permissions that field requires.
+func isUnauthorizedMessage(message string) bool {
+	return strings.EqualFold(strings.TrimSpace(message), "unauthorized")
+}
+
+// wrapError converts errors returned by the GraphQL library into the error types
+// defined by this package. Context errors are returned as they are, so that errors.Is
+// keeps working for them.
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	var networkErr graphql.NetworkError
+	if errors.As(err, &networkErr) {
+		return &HTTPError{StatusCode: networkErr.StatusCode(), Body: networkErr.Body()}
+	}
+
+	var graphqlErrs graphql.Errors
+	if !errors.As(err, &graphqlErrs) {
+		return err
+	}
+
+	out := &GraphQLErrors{Errors: make([]GraphQLError, 0, len(graphqlErrs))}
+	for _, graphqlErr := range graphqlErrs {
+		if graphqlErr.Extensions["code"] == graphql.ErrRequestError {
+			return &TransportError{Err: graphqlErr.Unwrap()}
+		}
+
+		out.Errors = append(out.Errors, GraphQLError{
+			Message:    graphqlErr.Message,
+			Path:       graphqlErr.Path,
+			Extensions: graphqlErr.Extensions,
+		})
+	}
+
+	return out
+}
@@ -6,6 +6,10 @@ import (
 
 // Client abstracts away Spacelift's client API.
 type Client interface {
-	// Query executes a single GraphQL query request.
+	// Query executes a single GraphQL query request. If the response contains data for
+	// some fields and errors for others, the data is decoded into the query and a
+	// *PartialError describing the failed fields is returned. Other failures are
+	// reported as ErrUnauthorized, *HTTPError, *TransportError or *GraphQLErrors, which
+	// can be told apart with errors.Is and errors.As.
 	Query(context.Context, interface{}, map[string]interface{}) error
 }
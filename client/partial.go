@@ -0,0 +1,124 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FieldError is an error the Spacelift API returned for a single field of a query.
+type FieldError struct {
+	// Path is the dot-separated path of the field in the query, with list indexes
+	// removed so that it can be used as a metric label.
+	Path    string
+	Message string
+
+	extensions map[string]any
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Is makes field errors whose code reports an authorization failure match
+// ErrUnauthorized.
+func (e *FieldError) Is(target error) bool {
+	unauthorized, _ := isUnauthorized(e.extensions)
+	return target == ErrUnauthorized && unauthorized
+}
+
+// PartialError is returned by Query when the Spacelift API returned data for some
+// fields of a query alongside errors for others. At least one of the fields returned
+// wasn't null, otherwise Query returns the *GraphQLErrors alone. Everything that was returned has been
+// decoded into the query, with the failed fields left at their zero values.
+type PartialError struct {
+	Fields []FieldError
+
+	errs *GraphQLErrors
+}
+
+func (e *PartialError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		parts = append(parts, field.Error())
+	}
+
+	return fmt.Sprintf("partial response from the Spacelift API: %s", strings.Join(parts, "; "))
+}
+
+// Unwrap returns the underlying *GraphQLErrors.
+func (e *PartialError) Unwrap() error {
+	return e.errs
+}
+
+// IsPartial returns true if the error means that a query returned partial data.
+func IsPartial(err error) bool {
+	var partial *PartialError
+	return errors.As(err, &partial)
+}
+
+// asPartialError converts errors returned alongside data into a PartialError. Errors
+// without a path didn't come from resolving a field, so they're returned unchanged.
+func asPartialError(err error) error {
+	var graphqlErrs *GraphQLErrors
+	if !errors.As(err, &graphqlErrs) {
+		return err
+	}
+
+	partial := &PartialError{Fields: make([]FieldError, 0, len(graphqlErrs.Errors)), errs: graphqlErrs}
+	for _, graphqlErr := range graphqlErrs.Errors {
+		if len(graphqlErr.Path) == 0 {
+			return err
+		}
+
+		partial.Fields = append(partial.Fields, FieldError{
+			Path:       fieldPath(graphqlErr.Path),
+			Message:    graphqlErr.Message,
+			extensions: graphqlErr.Extensions,
+		})
+	}
+
+	return partial
+}
+
+func fieldPath(path []any) string {
+	parts := make([]string, 0, len(path))
+	for _, element := range path {
+		if name, ok := element.(string); ok {
+			parts = append(parts, name)
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// hasData returns true if the data of a GraphQL response has at least one field that
+// isn't null. Lists count as data even when they're empty, since an empty list is a
+// valid answer while a null is what failed fields resolve to.
+func hasData(data []byte) bool {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		// The response will fail to decode anyway.
+		return true
+	}
+
+	return nonNull(value)
+}
+
+func nonNull(value any) bool {
+	switch value := value.(type) {
+	case nil:
+		return false
+	case map[string]any:
+		for _, field := range value {
+			if nonNull(field) {
+				return true
+			}
+		}
+
+		return false
+	default:
+		return true
+	}
+}
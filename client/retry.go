@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how Query retries requests that failed for transient reasons,
+// such as network errors, rate limiting or gateway errors. Retries never outlive the
+// deadline of the context passed to Query.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one. Values
+	// below 1 are treated as 1.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. It doubles with every
+	// subsequent retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries. A Retry-After header sent by the API
+	// takes precedence over it.
+	MaxBackoff time.Duration
+
+	// Jitter randomises every delay by up to this fraction of it, so that clients don't
+	// retry in lockstep.
+	Jitter float64
+
+	// OnRetry, if set, is called before every retry with the reason for it.
+	OnRetry func(reason string)
+}
+
+// DefaultRetryPolicy returns the retry policy used by New.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+// The reasons passed to RetryPolicy.OnRetry.
+const (
+	RetryReasonUnauthorized = "unauthorized"
+	RetryReasonRateLimited  = "rate_limited"
+	RetryReasonServerError  = "server_error"
+	RetryReasonTransport    = "transport"
+)
+
+// rejected returns true if the Spacelift API rejected a whole request because of its
+// token, rather than refusing some fields because of the API key's permissions. That
+// is an HTTP 401, or a top-level unauthorized error without any data.
+func rejected(err error, data []byte) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusUnauthorized
+	}
+
+	var graphqlErrs *GraphQLErrors
+	if errors.As(err, &graphqlErrs) {
+		return graphqlErrs.rejectsRequest() && (len(data) == 0 || !hasData(data))
+	}
+
+	// The session failed to exchange the API key for a token.
+	return errors.Is(err, ErrUnauthorized)
+}
+
+// retryReason returns why a failed request is worth retrying, or false if it isn't.
+func retryReason(err error) (string, bool) {
+	var httpErr *HTTPError
+	var transportErr *TransportError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "", false
+	case errors.Is(err, ErrUnauthorized):
+		return RetryReasonUnauthorized, true
+	case errors.As(err, &httpErr):
+		switch httpErr.StatusCode {
+		case http.StatusTooManyRequests:
+			return RetryReasonRateLimited, true
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return RetryReasonServerError, true
+		default:
+			return "", false
+		}
+	case errors.As(err, &transportErr):
+		return RetryReasonTransport, true
+	default:
+		return "", false
+	}
+}
+
+// delay returns how long to wait before the given retry, counting from 1.
+func (p *RetryPolicy) delay(retry int, headers http.Header) time.Duration {
+	if retryAfter, ok := parseRetryAfter(headers.Get("Retry-After")); ok {
+		return retryAfter
+	}
+
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		//nolint:gosec // Jitter doesn't need a cryptographically secure source of randomness.
+		backoff += time.Duration(float64(backoff) * p.Jitter * (rand.Float64()*2 - 1))
+	}
+
+	return max(backoff, 0)
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+
+	return 0, false
+}
+
+// wait sleeps for the delay, returning false without waiting if the delay would take us
+// past the context deadline, or early if the context is cancelled.
+func wait(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -33,7 +33,28 @@ func FromAPIKeyProvider(ctx context.Context, client *http.Client, endpoint, keyI
 		return nil, fmt.Errorf("API key secret provider must not be nil")
 	}
 
-	out := &apiKey{
+	out := newAPIKey(client, endpoint, keyID, secret)
+
+	if err := out.exchange(ctx); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// LazyFromAPIKeyProvider builds a Spacelift session like FromAPIKeyProvider, but
+// only exchanges the API key for a token when the session is first used, so that
+// an endpoint that's unreachable right now doesn't prevent creating it.
+func LazyFromAPIKeyProvider(client *http.Client, endpoint, keyID string, secret SecretProvider) (Session, error) {
+	if secret == nil {
+		return nil, fmt.Errorf("API key secret provider must not be nil")
+	}
+
+	return newAPIKey(client, endpoint, keyID, secret), nil
+}
+
+func newAPIKey(client *http.Client, endpoint, keyID string, secret SecretProvider) *apiKey {
+	return &apiKey{
 		apiToken: apiToken{
 			client:   client,
 			endpoint: endpoint,
@@ -42,12 +63,6 @@ func FromAPIKeyProvider(ctx context.Context, client *http.Client, endpoint, keyI
 		keyID:  keyID,
 		secret: secret,
 	}
-
-	if err := out.exchange(ctx); err != nil {
-		return nil, err
-	}
-
-	return out, nil
 }
 
 type apiKey struct {
@@ -86,7 +101,7 @@ func (g *apiKey) exchange(ctx context.Context) error {
 	}
 
 	if err := g.mutate(ctx, &mutation, variables); err != nil {
-		return fmt.Errorf("could not exchange API key and secret for token: %w", err)
+		return fmt.Errorf("could not exchange API key and secret for token: %w", wrapExchangeError(err))
 	}
 
 	g.setJWT(&mutation.APIKeyUser)
@@ -0,0 +1,58 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hasura/go-graphql-client"
+)
+
+// ErrUnauthorized matches, using errors.Is, every error caused by the Spacelift API
+// rejecting an API key when exchanging it for a token.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// rejectedError is returned when the Spacelift API responded to a token exchange,
+// but refused the API key.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+func (e *rejectedError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+// wrapExchangeError makes errors of a token exchange that the Spacelift API rejected
+// match ErrUnauthorized. Errors that never got a response, or got one that couldn't be
+// decoded, are returned unchanged.
+func wrapExchangeError(err error) error {
+	var networkErr graphql.NetworkError
+	if errors.As(err, &networkErr) {
+		if networkErr.StatusCode() == http.StatusUnauthorized || networkErr.StatusCode() == http.StatusForbidden {
+			return &rejectedError{err: err}
+		}
+
+		return err
+	}
+
+	var graphqlErrs graphql.Errors
+	if !errors.As(err, &graphqlErrs) {
+		return err
+	}
+
+	for _, graphqlErr := range graphqlErrs {
+		switch graphqlErr.Extensions["code"] {
+		case graphql.ErrRequestError, graphql.ErrJsonDecode, graphql.ErrGraphQLDecode, graphql.ErrGraphQLExtensionsDecode:
+			return err
+		}
+	}
+
+	return &rejectedError{err: err}
+}
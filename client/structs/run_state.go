@@ -2,3 +2,41 @@ package structs
 
 // RunState is the state of the run.
 type RunState string
+
+// The run states that the exporter needs to reason about.
+const (
+	RunStateQueued          RunState = "QUEUED"
+	RunStateReady           RunState = "READY"
+	RunStatePreparing       RunState = "PREPARING"
+	RunStateInitializing    RunState = "INITIALIZING"
+	RunStatePlanning        RunState = "PLANNING"
+	RunStateUnconfirmed     RunState = "UNCONFIRMED"
+	RunStateConfirmed       RunState = "CONFIRMED"
+	RunStatePendingReview   RunState = "PENDING_REVIEW"
+	RunStatePreparingApply  RunState = "PREPARING_APPLY"
+	RunStateApplying        RunState = "APPLYING"
+	RunStatePerforming      RunState = "PERFORMING"
+	RunStateDestroying      RunState = "DESTROYING"
+	RunStateReplanRequested RunState = "REPLAN_REQUESTED"
+	RunStateFinished        RunState = "FINISHED"
+	RunStateFailed          RunState = "FAILED"
+	RunStateStopped         RunState = "STOPPED"
+	RunStateCanceled        RunState = "CANCELED"
+	RunStateDiscarded       RunState = "DISCARDED"
+	RunStateSkipped         RunState = "SKIPPED"
+)
+
+// IsTerminal returns true if a run can no longer transition out of the state.
+func (s RunState) IsTerminal() bool {
+	switch s {
+	case RunStateFinished, RunStateFailed, RunStateStopped, RunStateCanceled, RunStateDiscarded, RunStateSkipped:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsQueued returns true if the run is still waiting to be picked up by a worker.
+func (s RunState) IsQueued() bool {
+	return s == RunStateQueued || s == RunStateReady
+}
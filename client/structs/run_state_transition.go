@@ -35,7 +35,7 @@ func (r *RunStateTransition) About() string {
 }
 
 func (r *RunStateTransition) Error() error {
-	if r.State == RunState("FINISHED") {
+	if r.State == RunStateFinished {
 		return nil
 	}
 
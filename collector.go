@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -16,276 +20,402 @@ import (
 )
 
 type spaceliftCollector struct {
-	ctx                                    context.Context
-	logger                                 *zap.SugaredLogger
-	client                                 client.Client
-	scrapeTimeout                          time.Duration
-	publicRunsPending                      *prometheus.Desc
-	publicWorkersBusy                      *prometheus.Desc
-	publicParallelism                      *prometheus.Desc
-	workerPoolRunsPending                  *prometheus.Desc
-	workerPoolWorkersBusy                  *prometheus.Desc
-	workerPoolWorkers                      *prometheus.Desc
-	workerPoolWorkersDrained               *prometheus.Desc
-	currentBillingPeriodStart              *prometheus.Desc
-	currentBillingPeriodEnd                *prometheus.Desc
-	currentBillingPeriodUsedPrivateSeconds *prometheus.Desc
-	currentBillingPeriodUsedPublicSeconds  *prometheus.Desc
-	currentBillingPeriodUsedSeats          *prometheus.Desc
-	currentStacksCountByState              *prometheus.Desc
-	currentResourcesCountByDrift           *prometheus.Desc
-	currentAvgStackSizeByResourceCount     *prometheus.Desc
-	currentAverageRunDuration              *prometheus.Desc
-	currentMedianRunDuration               *prometheus.Desc
-	scrapeDuration                         *prometheus.Desc
-	buildInfo                              *prometheus.Desc
+	ctx                 context.Context
+	logger              *zap.SugaredLogger
+	client              client.Client
+	scrapeTimeout       time.Duration
+	pollInterval        time.Duration
+	collectorNames      []string
+	collectors          map[string]subCollector
+	snapshots           snapshotCache
+	lastSuccess         atomic.Int64
+	scrapeErrors        *prometheus.CounterVec
+	retries             *prometheus.CounterVec
+	up                  *prometheus.Desc
+	lastSuccessTime     *prometheus.Desc
+	collectorSuccess    *prometheus.Desc
+	collectorDuration   *prometheus.Desc
+	collectorFieldError *prometheus.Desc
+	scrapeDuration      *prometheus.Desc
+	snapshotAge         *prometheus.Desc
+	snapshotLastSuccess *prometheus.Desc
+	buildInfo           *prometheus.Desc
 }
 
-func newSpaceliftCollector(ctx context.Context, httpClient *http.Client, session session.Session, scrapeTimeout time.Duration) (prometheus.Collector, error) {
+// collectorResult is the outcome of running a single sub-collector.
+type collectorResult struct {
+	metrics  []prometheus.Metric
+	err      error
+	duration time.Duration
+
+	// fieldErrors lists the fields the API returned errors for alongside partial data.
+	fieldErrors []client.FieldError
+
+	// cached holds the metrics of the last successful poll when this one failed, so that
+	// scrapes keep serving them without received reporting the failed poll as a success.
+	cached []prometheus.Metric
+
+	// takenAt is when metrics were collected. In polling mode a failed poll keeps the
+	// timestamp of the last successful one.
+	takenAt time.Time
+}
+
+// received returns true if the sub-collector got at least some data from the API. A
+// partial response only counts if something was decoded into metrics, so that a
+// response where every field failed doesn't pass for a successful scrape.
+func (r *collectorResult) received() bool {
+	return r.err == nil || (len(r.fieldErrors) > 0 && len(r.metrics) > 0)
+}
+
+// served returns the metrics a scrape should expose: those of this result if it was
+// received, or otherwise the ones cached from the last successful poll.
+func (r *collectorResult) served() []prometheus.Metric {
+	if r.received() {
+		return r.metrics
+	}
+
+	return r.cached
+}
+
+// collectorOptions controls how a spaceliftCollector queries the Spacelift API.
+type collectorOptions struct {
+	// scrapeTimeout bounds every sub-collector's requests, including retries.
+	scrapeTimeout time.Duration
+
+	// pollInterval, if greater than zero, makes the collector poll the Spacelift API in
+	// the background and serve scrapes from the latest snapshot. Otherwise the API is
+	// queried on every scrape.
+	pollInterval time.Duration
+
+	// collectors are the names of the sub-collectors to run.
+	collectors []string
+
+	// retryPolicy controls how failed requests to the Spacelift API are retried.
+	retryPolicy client.RetryPolicy
+
+	// billing describes the account's contract, to forecast its usage and cost.
+	billing billingConfig
+
+	// replaced, if set, is a collector with the same settings that this one replaces
+	// after a reload. Its latest snapshot is served until this collector's first poll
+	// finishes.
+	replaced *spaceliftCollector
+}
+
+// newSpaceliftCollector creates a collector for the account the session belongs to.
+// Background polling, if enabled, runs until ctx is cancelled.
+func newSpaceliftCollector(ctx context.Context, httpClient *http.Client, session session.Session, options collectorOptions) (*spaceliftCollector, error) {
 	buildInfo, ok := debug.ReadBuildInfo()
 	if !ok {
 		return nil, errors.New("could not read build info")
 	}
 
-	return &spaceliftCollector{
-		ctx:           ctx,
-		logger:        logging.FromContext(ctx).Sugar(),
-		client:        client.New(httpClient, session),
-		scrapeTimeout: scrapeTimeout,
-		publicRunsPending: prometheus.NewDesc(
-			"spacelift_public_worker_pool_runs_pending",
-			"The number of runs in your account currently queued and waiting for a public worker",
-			nil,
-			nil),
-		publicWorkersBusy: prometheus.NewDesc(
-			"spacelift_public_worker_pool_workers_busy",
-			"The number of currently busy workers in the public worker pool for this account",
-			nil,
-			nil),
-		publicParallelism: prometheus.NewDesc(
-			"spacelift_public_worker_pool_parallelism",
-			"The maximum number of simultaneously executing runs on the public worker pool for this account",
-			nil,
-			nil),
-		workerPoolRunsPending: prometheus.NewDesc(
-			"spacelift_worker_pool_runs_pending",
-			"The number of runs currently queued and waiting for a worker from a particular pool",
-			[]string{"worker_pool_id", "worker_pool_name"},
-			nil),
-		workerPoolWorkersBusy: prometheus.NewDesc(
-			"spacelift_worker_pool_workers_busy",
-			"The number of currently busy workers in a worker pool",
-			[]string{"worker_pool_id", "worker_pool_name"},
-			nil),
-		workerPoolWorkers: prometheus.NewDesc(
-			"spacelift_worker_pool_workers",
-			"The number of workers in a worker pool",
-			[]string{"worker_pool_id", "worker_pool_name"},
-			nil),
-		workerPoolWorkersDrained: prometheus.NewDesc(
-			"spacelift_worker_pool_workers_drained",
-			"The number of workers in a worker pool that have been drained",
-			[]string{"worker_pool_id", "worker_pool_name"},
-			nil),
-		currentBillingPeriodStart: prometheus.NewDesc(
-			"spacelift_current_billing_period_start_timestamp_seconds",
-			"The timestamp of the start of the current billing period",
-			nil,
-			nil),
-		currentBillingPeriodEnd: prometheus.NewDesc(
-			"spacelift_current_billing_period_end_timestamp_seconds",
-			"The timestamp of the end of the current billing period",
-			nil,
-			nil),
-		currentBillingPeriodUsedPrivateSeconds: prometheus.NewDesc(
-			"spacelift_current_billing_period_used_private_seconds",
-			"The amount of private worker usage in the current billing period",
-			nil,
-			nil),
-		currentBillingPeriodUsedPublicSeconds: prometheus.NewDesc(
-			"spacelift_current_billing_period_used_public_seconds",
-			"The amount of public worker usage in the current billing period",
+	collectorNames := options.collectors
+	collectors := make(map[string]subCollector, len(collectorNames))
+	for _, name := range collectorNames {
+		registration, ok := subCollectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		collectors[name] = registration.factory()
+
+		if configurable, ok := collectors[name].(configurableSubCollector); ok {
+			configurable.configure(options)
+		}
+	}
+
+	collector := &spaceliftCollector{
+		ctx:            ctx,
+		logger:         logging.FromContext(ctx).Sugar(),
+		scrapeTimeout:  options.scrapeTimeout,
+		pollInterval:   options.pollInterval,
+		collectorNames: collectorNames,
+		collectors:     collectors,
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spacelift_scrape_errors_total",
+			Help: "The number of failed requests to the Spacelift API, by class of error",
+		}, []string{"class"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spacelift_api_retries_total",
+			Help: "The number of requests to the Spacelift API that were retried, by reason",
+		}, []string{"reason"}),
+		up: prometheus.NewDesc(
+			"spacelift_up",
+			"Whether the last scrape of the Spacelift API returned data for at least one collector (1) or not (0)",
 			nil,
 			nil),
-		currentBillingPeriodUsedSeats: prometheus.NewDesc(
-			"spacelift_current_billing_period_used_seats",
-			"The number of seats used in the current billing period",
+		lastSuccessTime: prometheus.NewDesc(
+			"spacelift_last_successful_scrape_timestamp_seconds",
+			"The timestamp of the last scrape of the Spacelift API that returned data for at least one collector",
 			nil,
 			nil),
-		currentStacksCountByState: prometheus.NewDesc(
-			"spacelift_current_stacks_count_by_state",
-			"The number of stacks grouped by state",
-			[]string{"state"},
-			nil),
-		currentResourcesCountByDrift: prometheus.NewDesc(
-			"spacelift_current_resources_count_by_drift",
-			"The number of drifted resources",
-			[]string{"state"},
+		collectorSuccess: prometheus.NewDesc(
+			"spacelift_collector_success",
+			"Whether the last run of a collector succeeded without any errors (1) or not (0)",
+			[]string{"collector"},
 			nil),
-		currentAvgStackSizeByResourceCount: prometheus.NewDesc(
-			"spacelift_current_avg_stack_size_by_resource_count",
-			"The average stack size by resource count",
-			nil,
-			nil),
-		currentAverageRunDuration: prometheus.NewDesc(
-			"spacelift_current_average_run_duration",
-			"The average run duration",
-			nil,
+		collectorDuration: prometheus.NewDesc(
+			"spacelift_collector_duration_seconds",
+			"The duration in seconds of the last run of a collector",
+			[]string{"collector"},
 			nil),
-		currentMedianRunDuration: prometheus.NewDesc(
-			"spacelift_current_median_run_duration",
-			"The median run duration",
-			nil,
+		collectorFieldError: prometheus.NewDesc(
+			"spacelift_collector_field_error",
+			"Set to 1 for every field the Spacelift API returned an error for in the last run of a collector, while still returning data for the other fields",
+			[]string{"collector", "field"},
 			nil),
 		scrapeDuration: prometheus.NewDesc(
 			"spacelift_scrape_duration_seconds",
 			"The duration in seconds of the request to the Spacelift API for metrics",
 			nil,
 			nil),
+		snapshotAge: prometheus.NewDesc(
+			"spacelift_snapshot_age_seconds",
+			"The number of seconds since the metrics snapshot of a collector being served was taken. Only reported when background polling is enabled",
+			[]string{"collector"},
+			nil),
+		snapshotLastSuccess: prometheus.NewDesc(
+			"spacelift_snapshot_last_success_timestamp_seconds",
+			"The timestamp of the last successful background poll of a collector. Only reported when background polling is enabled",
+			[]string{"collector"},
+			nil),
 		buildInfo: prometheus.NewDesc(
 			"spacelift_build_info",
 			"Contains build information about the exporter",
 			nil,
 			prometheus.Labels{"version": version, "commit": commit, "goversion": buildInfo.GoVersion}),
-	}, nil
+	}
+
+	retryPolicy := options.retryPolicy
+	retryPolicy.OnRetry = func(reason string) {
+		collector.retries.WithLabelValues(reason).Inc()
+	}
+	collector.client = client.NewWithRetryPolicy(httpClient, session, retryPolicy)
+
+	if replaced := options.replaced; replaced != nil {
+		collector.snapshots.results, collector.snapshots.scrapeDuration = replaced.snapshots.load()
+		collector.lastSuccess.Store(replaced.lastSuccess.Load())
+	}
+
+	if collector.pollInterval > 0 {
+		go collector.poll(ctx)
+	}
+
+	return collector, nil
 }
 
 func (c *spaceliftCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
-	descriptorChannel <- c.publicRunsPending
-	descriptorChannel <- c.publicWorkersBusy
-	descriptorChannel <- c.publicParallelism
-	descriptorChannel <- c.workerPoolRunsPending
-	descriptorChannel <- c.workerPoolWorkersBusy
-	descriptorChannel <- c.workerPoolWorkersDrained
-	descriptorChannel <- c.currentBillingPeriodStart
-	descriptorChannel <- c.currentBillingPeriodEnd
-	descriptorChannel <- c.currentBillingPeriodUsedPrivateSeconds
-	descriptorChannel <- c.currentBillingPeriodUsedPublicSeconds
-	descriptorChannel <- c.currentBillingPeriodUsedSeats
-	descriptorChannel <- c.currentStacksCountByState
-	descriptorChannel <- c.currentResourcesCountByDrift
-	descriptorChannel <- c.currentAvgStackSizeByResourceCount
-	descriptorChannel <- c.currentAverageRunDuration
-	descriptorChannel <- c.currentMedianRunDuration
-	descriptorChannel <- c.buildInfo
+	c.describe(descriptorChannel, c.collectorNames)
 }
 
-type dataPoint struct {
-	Value  float64
-	Labels []string
+func (c *spaceliftCollector) Collect(metricChannel chan<- prometheus.Metric) {
+	c.collect(metricChannel, c.collectorNames)
 }
 
-type metricsQuery struct {
-	PublicWorkerPool struct {
-		Parallelism int `graphql:"parallelism"`
-		BusyWorkers int `graphql:"busyWorkers"`
-		PendingRuns int `graphql:"pendingRuns"`
-	} `graphql:"publicWorkerPool"`
-	WorkerPools []struct {
-		ID          string `graphql:"id"`
-		Name        string `graphql:"name"`
-		PendingRuns int    `graphql:"pendingRuns"`
-		BusyWorkers int    `graphql:"busyWorkers"`
-		Workers     []struct {
-			ID      string `graphql:"id"`
-			Drained bool   `graphql:"drained"`
-		} `graphql:"workers"`
-	} `graphql:"workerPools"`
-	Usage struct {
-		BillingPeriodStart int `graphql:"billingPeriodStart"`
-		BillingPeriodEnd   int `graphql:"billingPeriodEnd"`
-		UsedPrivateMinutes int `graphql:"usedPrivateMinutes"`
-		UsedPublicMinutes  int `graphql:"usedPublicMinutes"`
-		UsedSeats          int `graphql:"usedSeats"`
-	} `graphql:"usage"`
-	Metrics struct {
-		StacksCountByState          []dataPoint `graphql:"stacksCountByState"`
-		ResourcesCountByDrift       []dataPoint `graphql:"resourcesCountByDrift"`
-		AvgStackSizeByResourceCount []dataPoint `graphql:"avgStackSizeByResourceCount"`
-		AverageRunDuration          []dataPoint `graphql:"averageRunDuration"`
-		MedianRunDuration           []dataPoint `graphql:"medianRunDuration"`
-	} `graphql:"metrics"`
+// restrictedTo returns a view of the collector that only reports the named
+// sub-collectors, which must all be enabled on the collector. It backs the collect[]
+// URL parameter.
+func (c *spaceliftCollector) restrictedTo(names []string) (prometheus.Collector, error) {
+	names = slices.Compact(slices.Sorted(slices.Values(names)))
+	for _, name := range names {
+		if _, ok := c.collectors[name]; !ok {
+			return nil, fmt.Errorf("collector %q is not enabled", name)
+		}
+	}
+
+	return &restrictedCollector{parent: c, collectorNames: names}, nil
 }
 
-func (c *spaceliftCollector) Collect(metricChannel chan<- prometheus.Metric) {
-	var query metricsQuery
+type restrictedCollector struct {
+	parent         *spaceliftCollector
+	collectorNames []string
+}
 
-	start := time.Now()
-	err := func() error {
-		// The reason this is wrapped in an anonymous function is to ensure
-		// that the cancel function is called immediately after the query completes.
-
-		ctx, cancel := context.WithTimeout(c.ctx, c.scrapeTimeout)
-		defer cancel()
-		return c.client.Query(ctx, &query, nil)
-	}()
-
-	scrapeDuration := time.Since(start)
-	metricChannel <- prometheus.MustNewConstMetric(c.scrapeDuration, prometheus.GaugeValue, scrapeDuration.Seconds())
-
-	if err != nil {
-		msg := "Failed to request metrics from the Spacelift API"
-		if err == context.DeadlineExceeded {
-			msg = "The request to the Spacelift API for metric data timed out"
-		}
+func (r *restrictedCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	r.parent.describe(descriptorChannel, r.collectorNames)
+}
+
+func (r *restrictedCollector) Collect(metricChannel chan<- prometheus.Metric) {
+	r.parent.collect(metricChannel, r.collectorNames)
+}
+
+func (c *spaceliftCollector) describe(descriptorChannel chan<- *prometheus.Desc, names []string) {
+	for _, name := range names {
+		c.collectors[name].Describe(descriptorChannel)
+	}
+
+	c.scrapeErrors.Describe(descriptorChannel)
+	c.retries.Describe(descriptorChannel)
+	descriptorChannel <- c.up
+	descriptorChannel <- c.lastSuccessTime
+	descriptorChannel <- c.collectorSuccess
+	descriptorChannel <- c.collectorDuration
+	descriptorChannel <- c.collectorFieldError
+	descriptorChannel <- c.scrapeDuration
+	descriptorChannel <- c.buildInfo
+
+	if c.pollInterval > 0 {
+		descriptorChannel <- c.snapshotAge
+		descriptorChannel <- c.snapshotLastSuccess
+	}
+}
 
-		c.logger.Errorw(msg, zap.Error(err))
-		metricChannel <- prometheus.NewInvalidMetric(
-			prometheus.NewDesc(
-				"spacelift_error",
-				msg,
-				nil,
-				nil),
-			err)
+func (c *spaceliftCollector) collect(metricChannel chan<- prometheus.Metric, names []string) {
+	var results map[string]*collectorResult
+	var scrapeDuration time.Duration
 
-		return
+	if c.pollInterval > 0 {
+		results, scrapeDuration = c.snapshots.load()
+	} else {
+		results, scrapeDuration = c.scrape(names)
 	}
 
 	metricChannel <- prometheus.MustNewConstMetric(c.buildInfo, prometheus.GaugeValue, 1)
-	metricChannel <- prometheus.MustNewConstMetric(c.publicRunsPending, prometheus.GaugeValue, float64(query.PublicWorkerPool.PendingRuns))
-	metricChannel <- prometheus.MustNewConstMetric(c.publicWorkersBusy, prometheus.GaugeValue, float64(query.PublicWorkerPool.BusyWorkers))
-	metricChannel <- prometheus.MustNewConstMetric(c.publicParallelism, prometheus.GaugeValue, float64(query.PublicWorkerPool.Parallelism))
-	metricChannel <- prometheus.MustNewConstMetric(c.currentBillingPeriodStart, prometheus.GaugeValue, float64(query.Usage.BillingPeriodStart))
-	metricChannel <- prometheus.MustNewConstMetric(c.currentBillingPeriodEnd, prometheus.GaugeValue, float64(query.Usage.BillingPeriodEnd))
-	metricChannel <- prometheus.MustNewConstMetric(c.currentBillingPeriodUsedPrivateSeconds, prometheus.GaugeValue, float64(query.Usage.UsedPrivateMinutes*60))
-	metricChannel <- prometheus.MustNewConstMetric(c.currentBillingPeriodUsedPublicSeconds, prometheus.GaugeValue, float64(query.Usage.UsedPublicMinutes*60))
-	metricChannel <- prometheus.MustNewConstMetric(c.currentBillingPeriodUsedSeats, prometheus.GaugeValue, float64(query.Usage.UsedSeats))
-
-	for _, state := range query.Metrics.StacksCountByState {
-		if len(state.Labels) > 0 {
-			metricChannel <- prometheus.MustNewConstMetric(c.currentStacksCountByState, prometheus.GaugeValue, state.Value, state.Labels[0])
+
+	up := false
+	for _, name := range names {
+		result, ok := results[name]
+		if !ok {
+			// The first background poll hasn't finished yet.
+			continue
 		}
-	}
 
-	for _, state := range query.Metrics.ResourcesCountByDrift {
-		if len(state.Labels) > 0 {
-			metricChannel <- prometheus.MustNewConstMetric(c.currentResourcesCountByDrift, prometheus.GaugeValue, state.Value, state.Labels[0])
+		for _, metric := range result.served() {
+			metricChannel <- metric
+		}
+
+		metricChannel <- prometheus.MustNewConstMetric(c.collectorSuccess, prometheus.GaugeValue, boolToFloat(result.err == nil), name)
+		metricChannel <- prometheus.MustNewConstMetric(c.collectorDuration, prometheus.GaugeValue, result.duration.Seconds(), name)
+
+		fields := make(map[string]bool, len(result.fieldErrors))
+		for _, fieldErr := range result.fieldErrors {
+			if !fields[fieldErr.Path] {
+				fields[fieldErr.Path] = true
+				metricChannel <- prometheus.MustNewConstMetric(c.collectorFieldError, prometheus.GaugeValue, 1, name, fieldErr.Path)
+			}
+		}
+
+		if c.pollInterval > 0 && !result.takenAt.IsZero() {
+			metricChannel <- prometheus.MustNewConstMetric(c.snapshotAge, prometheus.GaugeValue, time.Since(result.takenAt).Seconds(), name)
+			metricChannel <- prometheus.MustNewConstMetric(c.snapshotLastSuccess, prometheus.GaugeValue, float64(result.takenAt.Unix()), name)
 		}
+
+		up = up || result.received()
+	}
+
+	// Until the first background poll finishes there's nothing to tell whether the API
+	// is up, and reporting it as down would be a false alarm.
+	if c.pollInterval == 0 || results != nil {
+		metricChannel <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, boolToFloat(up))
+	}
+	if lastSuccess := c.lastSuccess.Load(); lastSuccess > 0 {
+		metricChannel <- prometheus.MustNewConstMetric(c.lastSuccessTime, prometheus.GaugeValue, float64(lastSuccess))
 	}
 
-	if len(query.Metrics.AvgStackSizeByResourceCount) > 0 {
-		metricChannel <- prometheus.MustNewConstMetric(c.currentAvgStackSizeByResourceCount, prometheus.GaugeValue, query.Metrics.AvgStackSizeByResourceCount[0].Value)
+	if scrapeDuration > 0 {
+		metricChannel <- prometheus.MustNewConstMetric(c.scrapeDuration, prometheus.GaugeValue, scrapeDuration.Seconds())
 	}
 
-	if len(query.Metrics.AverageRunDuration) > 0 {
-		metricChannel <- prometheus.MustNewConstMetric(c.currentAverageRunDuration, prometheus.GaugeValue, query.Metrics.AverageRunDuration[0].Value)
+	c.scrapeErrors.Collect(metricChannel)
+	c.retries.Collect(metricChannel)
+}
+
+// scrape runs the named sub-collectors concurrently, each bounded by the scrape timeout,
+// and returns their results along with the total duration of the scrape.
+func (c *spaceliftCollector) scrape(names []string) (map[string]*collectorResult, time.Duration) {
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	var resultsMutex sync.Mutex
+	results := make(map[string]*collectorResult, len(names))
+
+	for _, name := range names {
+		wg.Go(func() {
+			result := c.runSubCollector(name)
+
+			resultsMutex.Lock()
+			defer resultsMutex.Unlock()
+			results[name] = result
+		})
 	}
 
-	if len(query.Metrics.MedianRunDuration) > 0 {
-		metricChannel <- prometheus.MustNewConstMetric(c.currentMedianRunDuration, prometheus.GaugeValue, query.Metrics.MedianRunDuration[0].Value)
+	wg.Wait()
+
+	for _, result := range results {
+		if result.received() {
+			c.lastSuccess.Store(time.Now().Unix())
+			break
+		}
 	}
 
-	for _, workerPool := range query.WorkerPools {
-		metricChannel <- prometheus.MustNewConstMetric(c.workerPoolRunsPending, prometheus.GaugeValue, float64(workerPool.PendingRuns), workerPool.ID, workerPool.Name)
-		metricChannel <- prometheus.MustNewConstMetric(c.workerPoolWorkersBusy, prometheus.GaugeValue, float64(workerPool.BusyWorkers), workerPool.ID, workerPool.Name)
-		metricChannel <- prometheus.MustNewConstMetric(c.workerPoolWorkers, prometheus.GaugeValue, float64(len(workerPool.Workers)), workerPool.ID, workerPool.Name)
+	return results, time.Since(start)
+}
+
+func (c *spaceliftCollector) runSubCollector(name string) *collectorResult {
+	ctx, cancel := context.WithTimeout(c.ctx, c.scrapeTimeout)
+	defer cancel()
 
-		drained := 0
-		for _, worker := range workerPool.Workers {
-			if worker.Drained {
-				drained++
-			}
+	start := time.Now()
+	metrics, err := c.collectors[name].Collect(ctx, c.client)
+	result := &collectorResult{metrics: metrics, err: err, duration: time.Since(start)}
+
+	var partial *client.PartialError
+	switch {
+	case errors.As(err, &partial):
+		// The field errors may still be authorization failures, which classifyError
+		// tells apart from other GraphQL errors.
+		class := classifyError(err)
+		result.fieldErrors = partial.Fields
+		c.scrapeErrors.WithLabelValues(class).Inc()
+		c.logger.Warnw("The Spacelift API returned partial data", "collector", name, "class", class, zap.Error(err))
+
+		if !result.received() {
+			return result
 		}
-		metricChannel <- prometheus.MustNewConstMetric(c.workerPoolWorkersDrained, prometheus.GaugeValue, float64(drained), workerPool.ID, workerPool.Name)
+	case err != nil:
+		class := classifyError(err)
+		c.scrapeErrors.WithLabelValues(class).Inc()
+		c.logger.Errorw(errorMessage(err), "collector", name, "class", class, zap.Error(err))
+		return result
+	}
+
+	result.takenAt = time.Now()
+
+	return result
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func errorMessage(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "The request to the Spacelift API for metric data timed out"
+	}
+
+	return "Failed to request metrics from the Spacelift API"
+}
+
+// classifyError sorts errors returned by the Spacelift client into the broad classes
+// reported by the spacelift_scrape_errors_total metric.
+func classifyError(err error) string {
+	var httpErr *client.HTTPError
+	var graphqlErrs *client.GraphQLErrors
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, client.ErrUnauthorized):
+		return "unauthorized"
+	case errors.As(err, &httpErr):
+		return "http"
+	case errors.As(err, &graphqlErrs):
+		return "graphql"
+	default:
+		return "transport"
 	}
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+// accountMetricsCollector exports the account-wide aggregates Spacelift computes for
+// its own dashboard.
+type accountMetricsCollector struct {
+	stacksCountByState          *prometheus.Desc
+	resourcesCountByDrift       *prometheus.Desc
+	avgStackSizeByResourceCount *prometheus.Desc
+	averageRunDuration          *prometheus.Desc
+	medianRunDuration           *prometheus.Desc
+}
+
+func newAccountMetricsCollector() subCollector {
+	return &accountMetricsCollector{
+		stacksCountByState: prometheus.NewDesc(
+			"spacelift_current_stacks_count_by_state",
+			"The number of stacks grouped by state",
+			[]string{"state"},
+			nil),
+		resourcesCountByDrift: prometheus.NewDesc(
+			"spacelift_current_resources_count_by_drift",
+			"The number of drifted resources",
+			[]string{"state"},
+			nil),
+		avgStackSizeByResourceCount: prometheus.NewDesc(
+			"spacelift_current_avg_stack_size_by_resource_count",
+			"The average stack size by resource count",
+			nil,
+			nil),
+		averageRunDuration: prometheus.NewDesc(
+			"spacelift_current_average_run_duration",
+			"The average run duration",
+			nil,
+			nil),
+		medianRunDuration: prometheus.NewDesc(
+			"spacelift_current_median_run_duration",
+			"The median run duration",
+			nil,
+			nil),
+	}
+}
+
+func (c *accountMetricsCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.stacksCountByState
+	descriptorChannel <- c.resourcesCountByDrift
+	descriptorChannel <- c.avgStackSizeByResourceCount
+	descriptorChannel <- c.averageRunDuration
+	descriptorChannel <- c.medianRunDuration
+}
+
+type dataPoint struct {
+	Value  float64
+	Labels []string
+}
+
+type accountMetricsQuery struct {
+	Metrics *struct {
+		StacksCountByState          []dataPoint `graphql:"stacksCountByState"`
+		ResourcesCountByDrift       []dataPoint `graphql:"resourcesCountByDrift"`
+		AvgStackSizeByResourceCount []dataPoint `graphql:"avgStackSizeByResourceCount"`
+		AverageRunDuration          []dataPoint `graphql:"averageRunDuration"`
+		MedianRunDuration           []dataPoint `graphql:"medianRunDuration"`
+	} `graphql:"metrics"`
+}
+
+func (c *accountMetricsCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query accountMetricsQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	aggregates := query.Metrics
+	if aggregates == nil {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, state := range aggregates.StacksCountByState {
+		if len(state.Labels) > 0 {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.stacksCountByState, prometheus.GaugeValue, state.Value, state.Labels[0]))
+		}
+	}
+
+	for _, state := range aggregates.ResourcesCountByDrift {
+		if len(state.Labels) > 0 {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.resourcesCountByDrift, prometheus.GaugeValue, state.Value, state.Labels[0]))
+		}
+	}
+
+	if len(aggregates.AvgStackSizeByResourceCount) > 0 {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.avgStackSizeByResourceCount, prometheus.GaugeValue, aggregates.AvgStackSizeByResourceCount[0].Value))
+	}
+
+	if len(aggregates.AverageRunDuration) > 0 {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.averageRunDuration, prometheus.GaugeValue, aggregates.AverageRunDuration[0].Value))
+	}
+
+	if len(aggregates.MedianRunDuration) > 0 {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.medianRunDuration, prometheus.GaugeValue, aggregates.MedianRunDuration[0].Value))
+	}
+
+	return metrics, err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+	"github.com/spacelift-io/prometheus-exporter/client/structs"
+)
+
+// activeRunsMaxPages limits how many pages of runs we read per stack looking for
+// active runs, so that a stack with many runs in flight can't make scrapes slow.
+const activeRunsMaxPages = 5
+
+type activeRunsCollector struct {
+	stackRuns         *prometheus.Desc
+	stackOldestRunAge *prometheus.Desc
+	spaceRuns         *prometheus.Desc
+	spaceOldestRunAge *prometheus.Desc
+}
+
+func newActiveRunsCollector() subCollector {
+	return &activeRunsCollector{
+		stackRuns: prometheus.NewDesc(
+			"spacelift_stack_active_runs",
+			"The number of runs of a stack currently in a non-terminal state, by state",
+			append(stackLabels, "state"),
+			nil),
+		stackOldestRunAge: prometheus.NewDesc(
+			"spacelift_stack_oldest_active_run_age_seconds",
+			"The number of seconds the oldest run of a stack in a non-terminal state has been in that state",
+			append(stackLabels, "state"),
+			nil),
+		spaceRuns: prometheus.NewDesc(
+			"spacelift_space_active_runs",
+			"The number of runs of the stacks in a space currently in a non-terminal state, by state",
+			[]string{"space_id", "state"},
+			nil),
+		spaceOldestRunAge: prometheus.NewDesc(
+			"spacelift_space_oldest_active_run_age_seconds",
+			"The number of seconds the oldest run of the stacks in a space in a non-terminal state has been in that state",
+			[]string{"space_id", "state"},
+			nil),
+	}
+}
+
+func (c *activeRunsCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.stackRuns
+	descriptorChannel <- c.stackOldestRunAge
+	descriptorChannel <- c.spaceRuns
+	descriptorChannel <- c.spaceOldestRunAge
+}
+
+type activeRunsQuery struct {
+	Stacks []struct {
+		stackIdentity
+		Runs []activeRun `graphql:"runs"`
+	} `graphql:"stacks"`
+}
+
+type activeRunsPageQuery struct {
+	Stack *struct {
+		Runs []activeRun `graphql:"runs(before: $before)"`
+	} `graphql:"stack(id: $id)"`
+}
+
+type activeRun struct {
+	ID        string                       `graphql:"id"`
+	State     structs.RunState             `graphql:"state"`
+	CreatedAt int                          `graphql:"createdAt"`
+	History   []structs.RunStateTransition `graphql:"history"`
+}
+
+func (r *activeRun) active() bool {
+	return r.State != "" && !r.State.IsTerminal()
+}
+
+// enteredStateAt returns when the run last transitioned to its current state, or
+// when it was created if its history doesn't say.
+func (r *activeRun) enteredStateAt() int {
+	enteredAt := 0
+	for _, transition := range r.History {
+		if transition.State == r.State && transition.Timestamp > enteredAt {
+			enteredAt = transition.Timestamp
+		}
+	}
+
+	if enteredAt == 0 {
+		return r.CreatedAt
+	}
+
+	return enteredAt
+}
+
+// activeRuns counts the runs in a state, and remembers when the one that's been in
+// it the longest entered it.
+type activeRuns struct {
+	count       int
+	oldestSince int
+}
+
+func (a *activeRuns) add(since int) {
+	if a.count == 0 || since < a.oldestSince {
+		a.oldestSince = since
+	}
+	a.count++
+}
+
+type spaceState struct {
+	space string
+	state structs.RunState
+}
+
+func (c *activeRunsCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query activeRunsQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	now := time.Now()
+	age := func(since int) float64 {
+		return now.Sub(time.Unix(int64(since), 0)).Seconds()
+	}
+
+	var metrics []prometheus.Metric
+	spaces := make(map[spaceState]*activeRuns)
+	for _, stack := range query.Stacks {
+		if stack.ID == "" {
+			// The API couldn't resolve this stack.
+			continue
+		}
+
+		runs, pageErr := pageActiveRuns(ctx, api, stack.ID, stack.Runs)
+		if pageErr != nil {
+			err = pageErr
+		}
+
+		states := make(map[structs.RunState]*activeRuns)
+		for _, run := range runs {
+			if !run.active() {
+				continue
+			}
+			since := run.enteredStateAt()
+
+			if states[run.State] == nil {
+				states[run.State] = &activeRuns{}
+			}
+			states[run.State].add(since)
+
+			key := spaceState{space: stack.Space, state: run.State}
+			if spaces[key] == nil {
+				spaces[key] = &activeRuns{}
+			}
+			spaces[key].add(since)
+		}
+
+		for state, runs := range states {
+			labels := append(stack.labelValues(), string(state))
+
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(c.stackRuns, prometheus.GaugeValue, float64(runs.count), labels...),
+				prometheus.MustNewConstMetric(c.stackOldestRunAge, prometheus.GaugeValue, age(runs.oldestSince), labels...),
+			)
+		}
+	}
+
+	for key, runs := range spaces {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.spaceRuns, prometheus.GaugeValue, float64(runs.count), key.space, string(key.state)),
+			prometheus.MustNewConstMetric(c.spaceOldestRunAge, prometheus.GaugeValue, age(runs.oldestSince), key.space, string(key.state)),
+		)
+	}
+
+	return metrics, err
+}
+
+// pageActiveRuns reads older pages of a stack's runs for as long as the last page read
+// has active runs, as the page before it may have more. Active runs further back than
+// a page of runs that are all terminal, or than activeRunsMaxPages, aren't counted.
+func pageActiveRuns(ctx context.Context, api client.Client, stackID string, runs []activeRun) ([]activeRun, error) {
+	page := runs
+	for pages := 1; pages < activeRunsMaxPages && len(page) > 0; pages++ {
+		if !slices.ContainsFunc(page, func(run activeRun) bool { return run.active() }) {
+			break
+		}
+
+		var query activeRunsPageQuery
+		if err := api.Query(ctx, &query, map[string]any{
+			"id":     graphql.ID(stackID),
+			"before": graphql.ID(page[len(page)-1].ID),
+		}); err != nil {
+			return runs, err
+		}
+
+		if query.Stack == nil {
+			break
+		}
+
+		page = query.Stack.Runs
+		runs = append(runs, page...)
+	}
+
+	return runs, nil
+}
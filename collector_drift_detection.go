@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+	"github.com/spacelift-io/prometheus-exporter/client/structs"
+)
+
+// driftDetectionMaxPages limits how many pages of runs we read per stack looking for
+// its latest drift detection runs. A stack with more recent runs than that reports
+// no drift detection run metrics rather than ones for an older run.
+const driftDetectionMaxPages = 5
+
+type driftDetectionCollector struct {
+	info             *prometheus.Desc
+	reconcile        *prometheus.Desc
+	nextSchedule     *prometheus.Desc
+	lastRunTimestamp *prometheus.Desc
+	lastRunState     *prometheus.Desc
+	driftedResources *prometheus.Desc
+}
+
+func newDriftDetectionCollector() subCollector {
+	return &driftDetectionCollector{
+		info: prometheus.NewDesc(
+			"spacelift_stack_drift_detection_info",
+			"Contains the drift detection settings of a stack with drift detection enabled, including its comma-separated list of cron schedules",
+			append(stackLabels, "schedule", "timezone"),
+			nil),
+		reconcile: prometheus.NewDesc(
+			"spacelift_stack_drift_detection_reconcile",
+			"Whether drift detection automatically reconciles the drift it finds on a stack (1) or not (0)",
+			stackLabels,
+			nil),
+		nextSchedule: prometheus.NewDesc(
+			"spacelift_stack_drift_detection_next_schedule_timestamp_seconds",
+			"The timestamp of the next scheduled drift detection run of a stack",
+			stackLabels,
+			nil),
+		lastRunTimestamp: prometheus.NewDesc(
+			"spacelift_stack_drift_detection_last_run_timestamp_seconds",
+			"The timestamp at which the last drift detection run of a stack was created",
+			stackLabels,
+			nil),
+		lastRunState: prometheus.NewDesc(
+			"spacelift_stack_drift_detection_last_run_state",
+			"The state of the last drift detection run of a stack. Always 1, with the state in the state label",
+			append(stackLabels, "state"),
+			nil),
+		driftedResources: prometheus.NewDesc(
+			"spacelift_stack_drifted_resources",
+			"The number of resources the last finished drift detection run of a stack found to have drifted",
+			stackLabels,
+			nil),
+	}
+}
+
+func (c *driftDetectionCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.info
+	descriptorChannel <- c.reconcile
+	descriptorChannel <- c.nextSchedule
+	descriptorChannel <- c.lastRunTimestamp
+	descriptorChannel <- c.lastRunState
+	descriptorChannel <- c.driftedResources
+}
+
+type driftDetectionQuery struct {
+	Stacks []struct {
+		stackIdentity
+		DriftDetectionIntegration *struct {
+			Reconcile    bool     `graphql:"reconcile"`
+			Schedule     []string `graphql:"schedule"`
+			Timezone     string   `graphql:"timezone"`
+			NextSchedule *int     `graphql:"nextSchedule"`
+		} `graphql:"driftDetectionIntegration"`
+		Runs []driftDetectionRun `graphql:"runs"`
+	} `graphql:"stacks"`
+}
+
+type driftDetectionRunsPageQuery struct {
+	Stack *struct {
+		Runs []driftDetectionRun `graphql:"runs(before: $before)"`
+	} `graphql:"stack(id: $id)"`
+}
+
+type driftDetectionRun struct {
+	ID             string           `graphql:"id"`
+	CreatedAt      int              `graphql:"createdAt"`
+	State          structs.RunState `graphql:"state"`
+	DriftDetection bool             `graphql:"driftDetection"`
+	Delta          *struct {
+		AddCount    int `graphql:"addCount"`
+		ChangeCount int `graphql:"changeCount"`
+		DeleteCount int `graphql:"deleteCount"`
+	} `graphql:"delta"`
+}
+
+func (c *driftDetectionCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query driftDetectionQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, stack := range query.Stacks {
+		integration := stack.DriftDetectionIntegration
+		if stack.ID == "" || integration == nil {
+			// Either the API couldn't resolve this stack, or it doesn't have drift
+			// detection enabled.
+			continue
+		}
+
+		labels := stack.labelValues()
+
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, append(labels, strings.Join(integration.Schedule, ","), integration.Timezone)...),
+			prometheus.MustNewConstMetric(c.reconcile, prometheus.GaugeValue, boolToFloat(integration.Reconcile), labels...),
+		)
+
+		if integration.NextSchedule != nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.nextSchedule, prometheus.GaugeValue, float64(*integration.NextSchedule), labels...))
+		}
+
+		lastRun, lastFinishedRun, pageErr := pageDriftDetectionRuns(ctx, api, stack.ID, stack.Runs)
+		if pageErr != nil {
+			err = pageErr
+		}
+
+		if lastRun != nil {
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(c.lastRunTimestamp, prometheus.GaugeValue, float64(lastRun.CreatedAt), labels...),
+				prometheus.MustNewConstMetric(c.lastRunState, prometheus.GaugeValue, 1, append(labels, string(lastRun.State))...),
+			)
+		}
+
+		if lastFinishedRun != nil && lastFinishedRun.Delta != nil {
+			delta := lastFinishedRun.Delta
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.driftedResources, prometheus.GaugeValue, float64(delta.AddCount+delta.ChangeCount+delta.DeleteCount), labels...))
+		}
+	}
+
+	return metrics, err
+}
+
+// pageDriftDetectionRuns looks for the latest drift detection runs of a stack, reading
+// older pages of its runs for as long as the ones read so far don't include a finished
+// drift detection run. The first page is newest first, so the runs found are the
+// latest ones, unless there are more than driftDetectionMaxPages pages of newer runs.
+func pageDriftDetectionRuns(ctx context.Context, api client.Client, stackID string, runs []driftDetectionRun) (last, lastFinished *driftDetectionRun, err error) {
+	page := runs
+	for pages := 1; pages < driftDetectionMaxPages && len(page) > 0; pages++ {
+		if _, finished := lastDriftDetectionRuns(runs); finished != nil {
+			break
+		}
+
+		var query driftDetectionRunsPageQuery
+		if err := api.Query(ctx, &query, map[string]any{
+			"id":     graphql.ID(stackID),
+			"before": graphql.ID(page[len(page)-1].ID),
+		}); err != nil {
+			// The runs we already have are still the latest ones.
+			last, lastFinished = lastDriftDetectionRuns(runs)
+			return last, lastFinished, err
+		}
+
+		if query.Stack == nil {
+			break
+		}
+
+		page = query.Stack.Runs
+		runs = append(runs, page...)
+	}
+
+	last, lastFinished = lastDriftDetectionRuns(runs)
+
+	return last, lastFinished, nil
+}
+
+// lastDriftDetectionRuns returns the most recent drift detection run, and the most
+// recent one that finished and so knows how many resources drifted.
+func lastDriftDetectionRuns(runs []driftDetectionRun) (last, lastFinished *driftDetectionRun) {
+	for i := range runs {
+		run := &runs[i]
+		if !run.DriftDetection {
+			continue
+		}
+
+		if last == nil || run.CreatedAt > last.CreatedAt {
+			last = run
+		}
+
+		if run.State == structs.RunStateFinished && (lastFinished == nil || run.CreatedAt > lastFinished.CreatedAt) {
+			lastFinished = run
+		}
+	}
+
+	return last, lastFinished
+}
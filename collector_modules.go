@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+// moduleLabels are the labels attached to every per-module metric.
+var moduleLabels = []string{"module_id", "module_name", "space_id"}
+
+type modulesCollector struct {
+	latestVersionTimestamp *prometheus.Desc
+	latestVersionState     *prometheus.Desc
+	versions               *prometheus.Desc
+	consumers              *prometheus.Desc
+}
+
+func newModulesCollector() subCollector {
+	return &modulesCollector{
+		latestVersionTimestamp: prometheus.NewDesc(
+			"spacelift_module_latest_version_timestamp_seconds",
+			"The timestamp at which the latest version of a module was published",
+			moduleLabels,
+			nil),
+		latestVersionState: prometheus.NewDesc(
+			"spacelift_module_latest_version_state",
+			"The state of the latest version of a module, which is FAILED if its tests failed. Always 1, with the version number and state in labels",
+			append(moduleLabels, "version", "state"),
+			nil),
+		versions: prometheus.NewDesc(
+			"spacelift_module_versions",
+			"The number of versions of a module, including failed ones",
+			moduleLabels,
+			nil),
+		consumers: prometheus.NewDesc(
+			"spacelift_module_consumers",
+			"The number of stacks using any version of a module",
+			moduleLabels,
+			nil),
+	}
+}
+
+func (c *modulesCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.latestVersionTimestamp
+	descriptorChannel <- c.latestVersionState
+	descriptorChannel <- c.versions
+	descriptorChannel <- c.consumers
+}
+
+type modulesQuery struct {
+	Modules []struct {
+		ID     string `graphql:"id"`
+		Name   string `graphql:"name"`
+		Space  string `graphql:"space"`
+		Latest *struct {
+			Number    string `graphql:"number"`
+			CreatedAt int    `graphql:"createdAt"`
+			State     string `graphql:"state"`
+		} `graphql:"latest"`
+		Versions []struct {
+			Consumers []struct {
+				ID string `graphql:"id"`
+			} `graphql:"consumers"`
+		} `graphql:"versions(includeFailed: true)"`
+	} `graphql:"modules"`
+}
+
+func (c *modulesCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query modulesQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, module := range query.Modules {
+		if module.ID == "" {
+			// The API couldn't resolve this module.
+			continue
+		}
+
+		labels := []string{module.ID, module.Name, module.Space}
+
+		// A stack can use several versions of the same module over time, so we count
+		// every consuming stack once.
+		consumers := make(map[string]struct{})
+		for _, version := range module.Versions {
+			for _, consumer := range version.Consumers {
+				consumers[consumer.ID] = struct{}{}
+			}
+		}
+
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.versions, prometheus.GaugeValue, float64(len(module.Versions)), labels...),
+			prometheus.MustNewConstMetric(c.consumers, prometheus.GaugeValue, float64(len(consumers)), labels...),
+		)
+
+		if module.Latest != nil {
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(c.latestVersionTimestamp, prometheus.GaugeValue, float64(module.Latest.CreatedAt), labels...),
+				prometheus.MustNewConstMetric(c.latestVersionState, prometheus.GaugeValue, 1, append(labels, module.Latest.Number, module.Latest.State)...),
+			)
+		}
+	}
+
+	return metrics, err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+// policyEvaluationWindow is how far back we count the sampled evaluations of a
+// policy.
+const policyEvaluationWindow = time.Hour
+
+// policyLabels are the labels attached to every per-policy metric.
+var policyLabels = []string{"policy_id", "policy_name", "type", "space_id"}
+
+type policiesCollector struct {
+	policies          *prometheus.Desc
+	attachedStacks    *prometheus.Desc
+	recentEvaluations *prometheus.Desc
+}
+
+func newPoliciesCollector() subCollector {
+	return &policiesCollector{
+		policies: prometheus.NewDesc(
+			"spacelift_policies",
+			"The number of policies by type and space",
+			[]string{"type", "space_id"},
+			nil),
+		attachedStacks: prometheus.NewDesc(
+			"spacelift_policy_attached_stacks",
+			"The number of stacks a policy is attached to",
+			policyLabels,
+			nil),
+		recentEvaluations: prometheus.NewDesc(
+			"spacelift_policy_recent_evaluations",
+			"The number of sampled evaluations of a policy in the last hour, by outcome. Only policies with sampling enabled report evaluations",
+			append(policyLabels, "outcome"),
+			nil),
+	}
+}
+
+func (c *policiesCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.policies
+	descriptorChannel <- c.attachedStacks
+	descriptorChannel <- c.recentEvaluations
+}
+
+type policiesQuery struct {
+	Policies []struct {
+		ID             string `graphql:"id"`
+		Name           string `graphql:"name"`
+		Type           string `graphql:"type"`
+		Space          string `graphql:"space"`
+		AttachedStacks []struct {
+			ID string `graphql:"id"`
+		} `graphql:"attachedStacks"`
+		EvaluationRecords []struct {
+			Outcome   string `graphql:"outcome"`
+			Timestamp int    `graphql:"timestamp"`
+		} `graphql:"evaluationRecords"`
+	} `graphql:"policies"`
+}
+
+type policyCountKey struct {
+	policyType string
+	space      string
+}
+
+func (c *policiesCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query policiesQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	windowStart := time.Now().Add(-policyEvaluationWindow).Unix()
+
+	var metrics []prometheus.Metric
+	counts := make(map[policyCountKey]int)
+	for _, policy := range query.Policies {
+		if policy.ID == "" {
+			// The API couldn't resolve this policy.
+			continue
+		}
+
+		counts[policyCountKey{policyType: policy.Type, space: policy.Space}]++
+
+		labels := []string{policy.ID, policy.Name, policy.Type, policy.Space}
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.attachedStacks, prometheus.GaugeValue, float64(len(policy.AttachedStacks)), labels...))
+
+		outcomes := make(map[string]int)
+		for _, record := range policy.EvaluationRecords {
+			if int64(record.Timestamp) >= windowStart {
+				outcomes[strings.ToLower(record.Outcome)]++
+			}
+		}
+
+		for outcome, count := range outcomes {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.recentEvaluations, prometheus.GaugeValue, float64(count), append(labels, outcome)...))
+		}
+	}
+
+	for key, count := range counts {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.policies, prometheus.GaugeValue, float64(count), key.policyType, key.space))
+	}
+
+	return metrics, err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+type publicWorkerPoolCollector struct {
+	runsPending *prometheus.Desc
+	workersBusy *prometheus.Desc
+	parallelism *prometheus.Desc
+}
+
+func newPublicWorkerPoolCollector() subCollector {
+	return &publicWorkerPoolCollector{
+		runsPending: prometheus.NewDesc(
+			"spacelift_public_worker_pool_runs_pending",
+			"The number of runs in your account currently queued and waiting for a public worker",
+			nil,
+			nil),
+		workersBusy: prometheus.NewDesc(
+			"spacelift_public_worker_pool_workers_busy",
+			"The number of currently busy workers in the public worker pool for this account",
+			nil,
+			nil),
+		parallelism: prometheus.NewDesc(
+			"spacelift_public_worker_pool_parallelism",
+			"The maximum number of simultaneously executing runs on the public worker pool for this account",
+			nil,
+			nil),
+	}
+}
+
+func (c *publicWorkerPoolCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.runsPending
+	descriptorChannel <- c.workersBusy
+	descriptorChannel <- c.parallelism
+}
+
+type publicWorkerPoolQuery struct {
+	PublicWorkerPool *struct {
+		Parallelism int `graphql:"parallelism"`
+		BusyWorkers int `graphql:"busyWorkers"`
+		PendingRuns int `graphql:"pendingRuns"`
+	} `graphql:"publicWorkerPool"`
+}
+
+func (c *publicWorkerPoolCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query publicWorkerPoolQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	pool := query.PublicWorkerPool
+	if pool == nil {
+		return nil, err
+	}
+
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(c.runsPending, prometheus.GaugeValue, float64(pool.PendingRuns)),
+		prometheus.MustNewConstMetric(c.workersBusy, prometheus.GaugeValue, float64(pool.BusyWorkers)),
+		prometheus.MustNewConstMetric(c.parallelism, prometheus.GaugeValue, float64(pool.Parallelism)),
+	}, err
+}
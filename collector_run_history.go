@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hasura/go-graphql-client"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+	"github.com/spacelift-io/prometheus-exporter/client/structs"
+)
+
+const (
+	// runHistoryLookback is how far back we look for finished runs the first time we
+	// see a stack.
+	runHistoryLookback = time.Hour
+
+	// runHistoryMaxPages limits how many pages of runs we read per stack and run, so
+	// that a stack with a very long history can't make scrapes slow.
+	runHistoryMaxPages = 5
+)
+
+// runHistoryLabels are the labels of the run duration histograms.
+var runHistoryLabels = []string{"worker_pool_id", "worker_pool_name", "run_type"}
+
+// runHistoryCollector observes the durations of every run that reached a terminal
+// state since the previous collection, based on the run's state history. Unlike
+// the other sub-collectors it's stateful: the histograms keep accumulating across
+// collections, and every stack remembers up to when it's been observed.
+type runHistoryCollector struct {
+	mutex      sync.Mutex
+	watermarks map[string]int
+
+	queueDuration       *prometheus.HistogramVec
+	executionDuration   *prometheus.HistogramVec
+	unconfirmedDuration *prometheus.HistogramVec
+}
+
+func newRunHistoryCollector() subCollector {
+	return &runHistoryCollector{
+		watermarks: make(map[string]int),
+		queueDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "spacelift_run_queue_duration_seconds",
+			Help:    "The time finished runs spent queued before starting, from their state history",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+		}, runHistoryLabels),
+		executionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "spacelift_run_execution_duration_seconds",
+			Help:    "The time finished runs took from starting to reaching a terminal state, from their state history",
+			Buckets: prometheus.ExponentialBuckets(10, 2, 12),
+		}, runHistoryLabels),
+		unconfirmedDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "spacelift_run_unconfirmed_duration_seconds",
+			Help:    "The time finished runs spent waiting for confirmation, from their state history",
+			Buckets: prometheus.ExponentialBuckets(10, 2, 12),
+		}, runHistoryLabels),
+	}
+}
+
+func (c *runHistoryCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	c.queueDuration.Describe(descriptorChannel)
+	c.executionDuration.Describe(descriptorChannel)
+	c.unconfirmedDuration.Describe(descriptorChannel)
+}
+
+type historyRun struct {
+	ID        string                       `graphql:"id"`
+	Type      structs.RunType              `graphql:"type"`
+	CreatedAt int                          `graphql:"createdAt"`
+	History   []structs.RunStateTransition `graphql:"history"`
+}
+
+type runHistoryQuery struct {
+	Stacks []runHistoryStack `graphql:"stacks"`
+}
+
+type runHistoryStack struct {
+	ID         string `graphql:"id"`
+	WorkerPool *struct {
+		ID   string `graphql:"id"`
+		Name string `graphql:"name"`
+	} `graphql:"workerPool"`
+	Runs []historyRun `graphql:"runs"`
+}
+
+type stackRunsPageQuery struct {
+	Stack *struct {
+		Runs []historyRun `graphql:"runs(before: $before)"`
+	} `graphql:"stack(id: $id)"`
+}
+
+func (c *runHistoryCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query runHistoryQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	// Stacks the API couldn't resolve may still exist, so we can only forget the
+	// watermarks of deleted stacks when the listing is complete.
+	if err == nil {
+		c.pruneWatermarks(query.Stacks)
+	}
+
+	defaultWatermark := int(time.Now().Add(-runHistoryLookback).Unix())
+
+	for _, stack := range query.Stacks {
+		if stack.ID == "" {
+			// The API couldn't resolve this stack.
+			continue
+		}
+
+		runs, pageErr := c.pageRuns(ctx, api, stack.ID, stack.Runs, c.watermark(stack.ID, defaultWatermark))
+		if pageErr != nil {
+			// We'll pick up from the same watermark on the next collection.
+			err = pageErr
+			continue
+		}
+
+		labels := []string{"public", "public"}
+		if stack.WorkerPool != nil {
+			labels = []string{stack.WorkerPool.ID, stack.WorkerPool.Name}
+		}
+
+		c.observe(stack.ID, runs, defaultWatermark, labels)
+	}
+
+	return collectMetrics(c.queueDuration, c.executionDuration, c.unconfirmedDuration), err
+}
+
+// watermark returns up to when a stack's runs have been observed.
+func (c *runHistoryCollector) watermark(stackID string, defaultWatermark int) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if watermark, ok := c.watermarks[stackID]; ok {
+		return watermark
+	}
+
+	return defaultWatermark
+}
+
+// pruneWatermarks forgets the watermarks of stacks that are no longer listed.
+func (c *runHistoryCollector) pruneWatermarks(stacks []runHistoryStack) {
+	listed := make(map[string]bool, len(stacks))
+	for _, stack := range stacks {
+		listed[stack.ID] = true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	maps.DeleteFunc(c.watermarks, func(stackID string, _ int) bool {
+		return !listed[stackID]
+	})
+}
+
+// pageRuns reads older pages of a stack's runs for as long as the last page read has
+// runs that are still in flight or finished after the watermark. Runs don't finish in
+// the order they were created, so a run created long ago, such as one waiting for
+// confirmation, can still finish after newer runs did.
+func (c *runHistoryCollector) pageRuns(ctx context.Context, api client.Client, stackID string, runs []historyRun, watermark int) ([]historyRun, error) {
+	page := runs
+	for pages := 1; pages < runHistoryMaxPages && len(page) > 0; pages++ {
+		if !slices.ContainsFunc(page, func(run historyRun) bool { return run.unfinishedAfter(watermark) }) {
+			break
+		}
+
+		var query stackRunsPageQuery
+		if err := api.Query(ctx, &query, map[string]any{
+			"id":     graphql.ID(stackID),
+			"before": graphql.ID(page[len(page)-1].ID),
+		}); err != nil {
+			return nil, err
+		}
+
+		if query.Stack == nil {
+			break
+		}
+
+		page = query.Stack.Runs
+		runs = append(runs, page...)
+	}
+
+	return runs, nil
+}
+
+// sortedHistory returns the run's state history sorted by timestamp.
+func (r *historyRun) sortedHistory() []structs.RunStateTransition {
+	history := slices.Clone(r.History)
+	slices.SortStableFunc(history, func(a, b structs.RunStateTransition) int {
+		return a.Timestamp - b.Timestamp
+	})
+
+	return history
+}
+
+// unfinishedAfter returns true if the run hadn't reached a terminal state by the
+// watermark.
+func (r *historyRun) unfinishedAfter(watermark int) bool {
+	history := r.sortedHistory()
+
+	return len(history) == 0 || !history[len(history)-1].Terminal || history[len(history)-1].Timestamp > watermark
+}
+
+// observe records the durations of a stack's runs that reached a terminal state after
+// its watermark, and moves the watermark past them. The watermark is read again here
+// rather than when the runs were queried, so that runs a concurrent collection already
+// observed aren't observed twice.
+func (c *runHistoryCollector) observe(stackID string, runs []historyRun, defaultWatermark int, workerPoolLabels []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	watermark, ok := c.watermarks[stackID]
+	if !ok {
+		watermark = defaultWatermark
+	}
+	next := watermark
+
+	for _, run := range runs {
+		history := run.sortedHistory()
+		if len(history) == 0 || !history[len(history)-1].Terminal {
+			continue
+		}
+
+		finishedAt := history[len(history)-1].Timestamp
+		if finishedAt <= watermark {
+			continue
+		}
+		next = max(next, finishedAt)
+
+		labels := append(slices.Clone(workerPoolLabels), string(run.Type))
+		durations := runDurationsFromHistory(run.CreatedAt, history)
+
+		if durations.queued != nil {
+			c.queueDuration.WithLabelValues(labels...).Observe(*durations.queued)
+		}
+
+		if durations.executed != nil {
+			c.executionDuration.WithLabelValues(labels...).Observe(*durations.executed)
+		}
+
+		if durations.unconfirmed != nil {
+			c.unconfirmedDuration.WithLabelValues(labels...).Observe(*durations.unconfirmed)
+		}
+	}
+
+	c.watermarks[stackID] = next
+}
+
+type runDurations struct {
+	queued      *float64
+	executed    *float64
+	unconfirmed *float64
+}
+
+// runDurationsFromHistory works out how long a finished run spent queued, executing
+// and waiting for confirmation, from its state history sorted by timestamp.
+func runDurationsFromHistory(createdAt int, history []structs.RunStateTransition) runDurations {
+	var durations runDurations
+	var startedAt, unconfirmedAt *int
+
+	for _, transition := range history {
+		switch {
+		case transition.Terminal:
+			if startedAt != nil {
+				durations.executed = new(float64(transition.Timestamp - *startedAt))
+			}
+		case transition.State.IsQueued():
+		case startedAt == nil:
+			startedAt = new(transition.Timestamp)
+			durations.queued = new(float64(transition.Timestamp - createdAt))
+		}
+
+		if unconfirmedAt != nil && durations.unconfirmed == nil {
+			durations.unconfirmed = new(float64(transition.Timestamp - *unconfirmedAt))
+		}
+
+		if transition.State == structs.RunStateUnconfirmed && unconfirmedAt == nil {
+			unconfirmedAt = new(transition.Timestamp)
+		}
+	}
+
+	return durations
+}
+
+// collectMetrics gathers the current metrics of stateful collectors, so that
+// sub-collectors can return them like any other metrics.
+func collectMetrics(collectors ...prometheus.Collector) []prometheus.Metric {
+	metricChannel := make(chan prometheus.Metric)
+
+	go func() {
+		defer close(metricChannel)
+
+		for _, collector := range collectors {
+			collector.Collect(metricChannel)
+		}
+	}()
+
+	var metrics []prometheus.Metric
+	for metric := range metricChannel {
+		metrics = append(metrics, metric)
+	}
+
+	return metrics
+}
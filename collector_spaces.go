@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+type spacesCollector struct {
+	info *prometheus.Desc
+}
+
+func newSpacesCollector() subCollector {
+	return &spacesCollector{
+		info: prometheus.NewDesc(
+			"spacelift_space_info",
+			"Contains information about a space, including its parent and its path from the root space. Join other metrics on space_id to aggregate them by space",
+			[]string{"space_id", "space_name", "parent_space_id", "path"},
+			nil),
+	}
+}
+
+func (c *spacesCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.info
+}
+
+type space struct {
+	ID          string  `graphql:"id"`
+	Name        string  `graphql:"name"`
+	ParentSpace *string `graphql:"parentSpace"`
+}
+
+type spacesQuery struct {
+	Spaces []space `graphql:"spaces"`
+}
+
+func (c *spacesCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query spacesQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	spaces := make(map[string]*space, len(query.Spaces))
+	for i := range query.Spaces {
+		if query.Spaces[i].ID != "" {
+			spaces[query.Spaces[i].ID] = &query.Spaces[i]
+		}
+	}
+
+	var metrics []prometheus.Metric
+	for _, space := range spaces {
+		var parent string
+		if space.ParentSpace != nil {
+			parent = *space.ParentSpace
+		}
+
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, space.ID, space.Name, parent, spacePath(spaces, space)))
+	}
+
+	return metrics, err
+}
+
+// spacePath returns the names of the space's ancestors and the space itself, joined
+// with slashes. Ancestors we can't see are left out.
+func spacePath(spaces map[string]*space, leaf *space) string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for current := leaf; current != nil && !seen[current.ID]; {
+		seen[current.ID] = true
+		names = append(names, current.Name)
+
+		if current.ParentSpace == nil {
+			break
+		}
+		current = spaces[*current.ParentSpace]
+	}
+
+	var path strings.Builder
+	for i := len(names) - 1; i >= 0; i-- {
+		path.WriteString("/")
+		path.WriteString(names[i])
+	}
+
+	return path.String()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+// stackLabels are the labels attached to every per-stack metric.
+var stackLabels = []string{"stack_id", "stack_name", "space_id", "administrative"}
+
+type stacksCollector struct {
+	info           *prometheus.Desc
+	state          *prometheus.Desc
+	stateTimestamp *prometheus.Desc
+	lastRun        *prometheus.Desc
+	locked         *prometheus.Desc
+	disabled       *prometheus.Desc
+	autodeploy     *prometheus.Desc
+	resources      *prometheus.Desc
+}
+
+func newStacksCollector() subCollector {
+	return &stacksCollector{
+		info: prometheus.NewDesc(
+			"spacelift_stack_info",
+			"Contains information about a stack, including its comma-separated list of labels",
+			append(stackLabels, "labels"),
+			nil),
+		state: prometheus.NewDesc(
+			"spacelift_stack_state",
+			"The current state of a stack. Always 1, with the state in the state label",
+			append(stackLabels, "state"),
+			nil),
+		stateTimestamp: prometheus.NewDesc(
+			"spacelift_stack_state_timestamp_seconds",
+			"The timestamp at which the stack entered its current state. Runs that don't change the stack's state, such as proposed runs, don't update it",
+			stackLabels,
+			nil),
+		lastRun: prometheus.NewDesc(
+			"spacelift_stack_last_run_timestamp_seconds",
+			"The timestamp at which the latest run of a stack was created",
+			stackLabels,
+			nil),
+		locked: prometheus.NewDesc(
+			"spacelift_stack_locked",
+			"Whether the stack is currently locked (1) or not (0)",
+			stackLabels,
+			nil),
+		disabled: prometheus.NewDesc(
+			"spacelift_stack_disabled",
+			"Whether the stack is disabled (1) or not (0)",
+			stackLabels,
+			nil),
+		autodeploy: prometheus.NewDesc(
+			"spacelift_stack_autodeploy",
+			"Whether the stack has autodeploy enabled (1) or not (0)",
+			stackLabels,
+			nil),
+		resources: prometheus.NewDesc(
+			"spacelift_stack_resources",
+			"The number of resources managed by the stack",
+			stackLabels,
+			nil),
+	}
+}
+
+func (c *stacksCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.info
+	descriptorChannel <- c.state
+	descriptorChannel <- c.stateTimestamp
+	descriptorChannel <- c.lastRun
+	descriptorChannel <- c.locked
+	descriptorChannel <- c.disabled
+	descriptorChannel <- c.autodeploy
+	descriptorChannel <- c.resources
+}
+
+type stacksQuery struct {
+	Stacks []stack `graphql:"stacks"`
+}
+
+// stackIdentity holds the fields of a stack that make up its stackLabels. Queries for
+// other per-stack metrics embed it.
+type stackIdentity struct {
+	ID             string `graphql:"id"`
+	Name           string `graphql:"name"`
+	Space          string `graphql:"space"`
+	Administrative bool   `graphql:"administrative"`
+}
+
+func (s *stackIdentity) labelValues() []string {
+	return []string{s.ID, s.Name, s.Space, strconv.FormatBool(s.Administrative)}
+}
+
+type stack struct {
+	stackIdentity
+	Labels      []string `graphql:"labels"`
+	State       string   `graphql:"state"`
+	StateSetAt  *int     `graphql:"stateSetAt"`
+	LockedBy    *string  `graphql:"lockedBy"`
+	IsDisabled  bool     `graphql:"isDisabled"`
+	Autodeploy  bool     `graphql:"autodeploy"`
+	EntityCount int      `graphql:"entityCount"`
+
+	// Runs is the latest page of the stack's runs, newest first.
+	Runs []struct {
+		CreatedAt int `graphql:"createdAt"`
+	} `graphql:"runs"`
+}
+
+func (c *stacksCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query stacksQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+	for _, stack := range query.Stacks {
+		if stack.ID == "" {
+			// The API couldn't resolve this stack.
+			continue
+		}
+
+		labels := stack.labelValues()
+
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, append(labels, strings.Join(stack.Labels, ","))...),
+			prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, 1, append(labels, stack.State)...),
+			prometheus.MustNewConstMetric(c.locked, prometheus.GaugeValue, boolToFloat(stack.LockedBy != nil), labels...),
+			prometheus.MustNewConstMetric(c.disabled, prometheus.GaugeValue, boolToFloat(stack.IsDisabled), labels...),
+			prometheus.MustNewConstMetric(c.autodeploy, prometheus.GaugeValue, boolToFloat(stack.Autodeploy), labels...),
+			prometheus.MustNewConstMetric(c.resources, prometheus.GaugeValue, float64(stack.EntityCount), labels...),
+		)
+
+		if stack.StateSetAt != nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.stateTimestamp, prometheus.GaugeValue, float64(*stack.StateSetAt), labels...))
+		}
+
+		if len(stack.Runs) > 0 {
+			lastRun := 0
+			for _, run := range stack.Runs {
+				lastRun = max(lastRun, run.CreatedAt)
+			}
+
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.lastRun, prometheus.GaugeValue, float64(lastRun), labels...))
+		}
+	}
+
+	return metrics, err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+	"github.com/spacelift-io/prometheus-exporter/client/session"
+	"github.com/spacelift-io/prometheus-exporter/logging"
+)
+
+// blockingSession holds every request to the Spacelift API until it's released, then
+// fails it.
+type blockingSession struct {
+	release chan struct{}
+}
+
+func (s *blockingSession) BearerToken(ctx context.Context) (string, error) {
+	select {
+	case <-s.release:
+		return "", errors.New("released")
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (s *blockingSession) Endpoint() string {
+	return "http://spacelift.invalid/graphql"
+}
+
+func (s *blockingSession) RefreshToken(context.Context) error {
+	return nil
+}
+
+func newPollingTestCollector(t *testing.T, ctx context.Context, replaced *spaceliftCollector) (*spaceliftCollector, *blockingSession) {
+	t.Helper()
+
+	session := &blockingSession{release: make(chan struct{})}
+	collector, err := newSpaceliftCollector(ctx, http.DefaultClient, session, collectorOptions{
+		scrapeTimeout: time.Minute,
+		pollInterval:  time.Hour,
+		collectors:    []string{"stacks"},
+		retryPolicy:   client.RetryPolicy{MaxAttempts: 1},
+		replaced:      replaced,
+	})
+	if err != nil {
+		t.Fatalf("could not create collector: %v", err)
+	}
+
+	return collector, session
+}
+
+func TestPollingCollectorUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(logging.Init(context.Background(), true))
+	t.Cleanup(cancel)
+
+	first, session := newPollingTestCollector(t, ctx, nil)
+
+	if count := testutil.CollectAndCount(first, "spacelift_up"); count != 0 {
+		t.Errorf("spacelift_up is reported before the first poll finished")
+	}
+
+	close(session.release)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if results, _ := first.snapshots.load(); results != nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the first poll didn't finish")
+		}
+	}
+
+	if count := testutil.CollectAndCount(first, "spacelift_up"); count != 1 {
+		t.Errorf("spacelift_up is not reported after the first poll")
+	}
+
+	// A collector replacing one with the same settings serves its snapshot until its
+	// own first poll finishes.
+	second, _ := newPollingTestCollector(t, ctx, first)
+
+	if count := testutil.CollectAndCount(second, "spacelift_up"); count != 1 {
+		t.Errorf("spacelift_up is not reported by the replacement collector")
+	}
+}
+
+func TestClassifySessionErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status int
+		body   string
+		want   string
+	}{
+		"rejected key": {
+			status: http.StatusOK,
+			body:   `{"data":{"apiKeyUser":null},"errors":[{"message":"unauthorized","path":["apiKeyUser"]}]}`,
+			want:   "unauthorized",
+		},
+		"unauthorized status": {
+			status: http.StatusUnauthorized,
+			want:   "unauthorized",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body)
+			}))
+			t.Cleanup(server.Close)
+
+			_, err := session.New(context.Background(), server.Client(), server.URL, "id", "secret")
+			if err == nil {
+				t.Fatal("session was created")
+			}
+
+			if class := classifyError(err); class != tc.want {
+				t.Errorf("got class %q for %v, want %q", class, err, tc.want)
+			}
+		})
+	}
+
+	// An unreachable API isn't a rejected key.
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	_, err := session.New(context.Background(), http.DefaultClient, server.URL, "id", "secret")
+	if class := classifyError(err); class != "transport" {
+		t.Errorf("got class %q for %v, want transport", class, err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+// usageCollector exports billing information. The usage field requires an admin API key.
+// With billing settings, it also forecasts the usage and cost of the billing period.
+type usageCollector struct {
+	billing billingConfig
+
+	billingPeriodStart                   *prometheus.Desc
+	billingPeriodEnd                     *prometheus.Desc
+	billingPeriodUsedPrivateSeconds      *prometheus.Desc
+	billingPeriodUsedPublicSeconds       *prometheus.Desc
+	billingPeriodUsedSeats               *prometheus.Desc
+	billingPeriodProjectedPrivateSeconds *prometheus.Desc
+	billingPeriodProjectedPublicSeconds  *prometheus.Desc
+	billingPeriodIncludedSeconds         *prometheus.Desc
+	billingPeriodIncludedUsedRatio       *prometheus.Desc
+	billingPeriodIncludedExhaustion      *prometheus.Desc
+	billingPeriodEstimatedCost           *prometheus.Desc
+	billingPeriodProjectedCost           *prometheus.Desc
+}
+
+func newUsageCollector() subCollector {
+	return &usageCollector{
+		billingPeriodStart: prometheus.NewDesc(
+			"spacelift_current_billing_period_start_timestamp_seconds",
+			"The timestamp of the start of the current billing period",
+			nil,
+			nil),
+		billingPeriodEnd: prometheus.NewDesc(
+			"spacelift_current_billing_period_end_timestamp_seconds",
+			"The timestamp of the end of the current billing period",
+			nil,
+			nil),
+		billingPeriodUsedPrivateSeconds: prometheus.NewDesc(
+			"spacelift_current_billing_period_used_private_seconds",
+			"The amount of private worker usage in the current billing period",
+			nil,
+			nil),
+		billingPeriodUsedPublicSeconds: prometheus.NewDesc(
+			"spacelift_current_billing_period_used_public_seconds",
+			"The amount of public worker usage in the current billing period",
+			nil,
+			nil),
+		billingPeriodUsedSeats: prometheus.NewDesc(
+			"spacelift_current_billing_period_used_seats",
+			"The number of seats used in the current billing period",
+			nil,
+			nil),
+		billingPeriodProjectedPrivateSeconds: prometheus.NewDesc(
+			"spacelift_current_billing_period_projected_private_seconds",
+			"The amount of private worker usage expected by the end of the current billing period, extrapolated linearly from the usage so far",
+			nil,
+			nil),
+		billingPeriodProjectedPublicSeconds: prometheus.NewDesc(
+			"spacelift_current_billing_period_projected_public_seconds",
+			"The amount of public worker usage expected by the end of the current billing period, extrapolated linearly from the usage so far",
+			nil,
+			nil),
+		billingPeriodIncludedSeconds: prometheus.NewDesc(
+			"spacelift_current_billing_period_included_seconds",
+			"The amount of worker usage included in the contract per billing period, by worker type",
+			[]string{"worker_type"},
+			nil),
+		billingPeriodIncludedUsedRatio: prometheus.NewDesc(
+			"spacelift_current_billing_period_included_used_ratio",
+			"The fraction of the worker usage included in the contract that has been used in the current billing period, by worker type",
+			[]string{"worker_type"},
+			nil),
+		billingPeriodIncludedExhaustion: prometheus.NewDesc(
+			"spacelift_current_billing_period_included_exhausted_timestamp_seconds",
+			"The timestamp at which the worker usage included in the contract runs out at the current rate, by worker type. Only set if that happens before the end of the billing period",
+			[]string{"worker_type"},
+			nil),
+		billingPeriodEstimatedCost: prometheus.NewDesc(
+			"spacelift_current_billing_period_estimated_cost",
+			"The estimated cost of the current billing period so far, based on the configured prices",
+			nil,
+			nil),
+		billingPeriodProjectedCost: prometheus.NewDesc(
+			"spacelift_current_billing_period_projected_cost",
+			"The estimated cost of the current billing period by its end, based on the configured prices and the projected usage",
+			nil,
+			nil),
+	}
+}
+
+func (c *usageCollector) configure(options collectorOptions) {
+	c.billing = options.billing
+}
+
+func (c *usageCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.billingPeriodStart
+	descriptorChannel <- c.billingPeriodEnd
+	descriptorChannel <- c.billingPeriodUsedPrivateSeconds
+	descriptorChannel <- c.billingPeriodUsedPublicSeconds
+	descriptorChannel <- c.billingPeriodUsedSeats
+	descriptorChannel <- c.billingPeriodProjectedPrivateSeconds
+	descriptorChannel <- c.billingPeriodProjectedPublicSeconds
+	descriptorChannel <- c.billingPeriodIncludedSeconds
+	descriptorChannel <- c.billingPeriodIncludedUsedRatio
+	descriptorChannel <- c.billingPeriodIncludedExhaustion
+	descriptorChannel <- c.billingPeriodEstimatedCost
+	descriptorChannel <- c.billingPeriodProjectedCost
+}
+
+type usageQuery struct {
+	Usage *struct {
+		BillingPeriodStart int `graphql:"billingPeriodStart"`
+		BillingPeriodEnd   int `graphql:"billingPeriodEnd"`
+		UsedPrivateMinutes int `graphql:"usedPrivateMinutes"`
+		UsedPublicMinutes  int `graphql:"usedPublicMinutes"`
+		UsedSeats          int `graphql:"usedSeats"`
+	} `graphql:"usage"`
+}
+
+func (c *usageCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query usageQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	usage := query.Usage
+	if usage == nil {
+		return nil, err
+	}
+
+	metrics := []prometheus.Metric{
+		prometheus.MustNewConstMetric(c.billingPeriodStart, prometheus.GaugeValue, float64(usage.BillingPeriodStart)),
+		prometheus.MustNewConstMetric(c.billingPeriodEnd, prometheus.GaugeValue, float64(usage.BillingPeriodEnd)),
+		prometheus.MustNewConstMetric(c.billingPeriodUsedPrivateSeconds, prometheus.GaugeValue, float64(usage.UsedPrivateMinutes*60)),
+		prometheus.MustNewConstMetric(c.billingPeriodUsedPublicSeconds, prometheus.GaugeValue, float64(usage.UsedPublicMinutes*60)),
+		prometheus.MustNewConstMetric(c.billingPeriodUsedSeats, prometheus.GaugeValue, float64(usage.UsedSeats)),
+	}
+
+	forecast, ok := newBillingForecast(usage.BillingPeriodStart, usage.BillingPeriodEnd, time.Now())
+	if !ok {
+		// The billing period hasn't started yet, so there's nothing to extrapolate from.
+		return metrics, err
+	}
+
+	private := forecast.workerType(float64(usage.UsedPrivateMinutes), c.billing.IncludedPrivateMinutes, c.billing.PrivateMinutePrice)
+	public := forecast.workerType(float64(usage.UsedPublicMinutes), c.billing.IncludedPublicMinutes, c.billing.PublicMinutePrice)
+
+	metrics = append(metrics,
+		prometheus.MustNewConstMetric(c.billingPeriodProjectedPrivateSeconds, prometheus.GaugeValue, private.projectedMinutes*60),
+		prometheus.MustNewConstMetric(c.billingPeriodProjectedPublicSeconds, prometheus.GaugeValue, public.projectedMinutes*60),
+	)
+
+	for workerType, minutes := range map[string]*workerTypeForecast{"private": &private, "public": &public} {
+		if minutes.includedMinutes <= 0 {
+			continue
+		}
+
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.billingPeriodIncludedSeconds, prometheus.GaugeValue, minutes.includedMinutes*60, workerType),
+			prometheus.MustNewConstMetric(c.billingPeriodIncludedUsedRatio, prometheus.GaugeValue, minutes.usedMinutes/minutes.includedMinutes, workerType),
+		)
+
+		if minutes.exhaustedAt != nil {
+			metrics = append(metrics, prometheus.MustNewConstMetric(c.billingPeriodIncludedExhaustion, prometheus.GaugeValue, *minutes.exhaustedAt, workerType))
+		}
+	}
+
+	if c.billing.priced() {
+		seats := c.billing.BasePrice + float64(usage.UsedSeats)*c.billing.SeatPrice
+
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.billingPeriodEstimatedCost, prometheus.GaugeValue, seats+private.cost+public.cost),
+			prometheus.MustNewConstMetric(c.billingPeriodProjectedCost, prometheus.GaugeValue, seats+private.projectedCost+public.projectedCost),
+		)
+	}
+
+	return metrics, err
+}
+
+// billingForecast extrapolates the usage of a billing period linearly from the part
+// of it that has elapsed.
+type billingForecast struct {
+	start   float64
+	elapsed float64
+	length  float64
+}
+
+// newBillingForecast returns false if no part of the billing period has elapsed yet.
+func newBillingForecast(start, end int, now time.Time) (billingForecast, bool) {
+	forecast := billingForecast{
+		start:  float64(start),
+		length: float64(end - start),
+	}
+	forecast.elapsed = min(float64(now.Unix())-forecast.start, forecast.length)
+
+	return forecast, forecast.elapsed > 0
+}
+
+type workerTypeForecast struct {
+	usedMinutes      float64
+	projectedMinutes float64
+	includedMinutes  float64
+
+	// exhaustedAt is when the included minutes run out at the current rate, if that
+	// happens before the end of the billing period.
+	exhaustedAt *float64
+
+	cost          float64
+	projectedCost float64
+}
+
+func (f billingForecast) workerType(usedMinutes, includedMinutes, price float64) workerTypeForecast {
+	forecast := workerTypeForecast{
+		usedMinutes:      usedMinutes,
+		projectedMinutes: usedMinutes / f.elapsed * f.length,
+		includedMinutes:  includedMinutes,
+	}
+
+	if includedMinutes > 0 && forecast.projectedMinutes > includedMinutes {
+		forecast.exhaustedAt = new(f.start + includedMinutes/usedMinutes*f.elapsed)
+	}
+
+	forecast.cost = max(usedMinutes-includedMinutes, 0) * price
+	forecast.projectedCost = max(forecast.projectedMinutes-includedMinutes, 0) * price
+
+	return forecast
+}
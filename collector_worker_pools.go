@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+// workerPoolLabels are the labels attached to every per-worker pool metric.
+var workerPoolLabels = []string{"worker_pool_id", "worker_pool_name"}
+
+// workerLabels are the labels of every per-worker metric. Apart from the IDs, they
+// come from the metadata the worker registered with.
+var workerLabels = []string{"worker_pool_id", "worker_pool_name", "worker_id", "hostname", "asg_id", "instance_id"}
+
+type workerPoolsCollector struct {
+	info               *prometheus.Desc
+	runsPending        *prometheus.Desc
+	workersBusy        *prometheus.Desc
+	workers            *prometheus.Desc
+	workersDrained     *prometheus.Desc
+	workerBusy         *prometheus.Desc
+	workerDrained      *prometheus.Desc
+	workerCreatedAt    *prometheus.Desc
+	workerHeartbeatAge *prometheus.Desc
+	workerVersion      *prometheus.Desc
+}
+
+func newWorkerPoolsCollector() subCollector {
+	return &workerPoolsCollector{
+		info: prometheus.NewDesc(
+			"spacelift_worker_pool_info",
+			"Contains information about a worker pool, including the space it belongs to",
+			append(workerPoolLabels, "space_id"),
+			nil),
+		runsPending: prometheus.NewDesc(
+			"spacelift_worker_pool_runs_pending",
+			"The number of runs currently queued and waiting for a worker from a particular pool",
+			workerPoolLabels,
+			nil),
+		workersBusy: prometheus.NewDesc(
+			"spacelift_worker_pool_workers_busy",
+			"The number of currently busy workers in a worker pool",
+			workerPoolLabels,
+			nil),
+		workers: prometheus.NewDesc(
+			"spacelift_worker_pool_workers",
+			"The number of workers in a worker pool",
+			workerPoolLabels,
+			nil),
+		workersDrained: prometheus.NewDesc(
+			"spacelift_worker_pool_workers_drained",
+			"The number of workers in a worker pool that have been drained",
+			workerPoolLabels,
+			nil),
+		workerBusy: prometheus.NewDesc(
+			"spacelift_worker_busy",
+			"Whether a worker is currently processing a run (1) or not (0)",
+			workerLabels,
+			nil),
+		workerDrained: prometheus.NewDesc(
+			"spacelift_worker_drained",
+			"Whether a worker has been drained (1) or not (0)",
+			workerLabels,
+			nil),
+		workerCreatedAt: prometheus.NewDesc(
+			"spacelift_worker_created_timestamp_seconds",
+			"The timestamp of when a worker registered with its worker pool",
+			workerLabels,
+			nil),
+		workerHeartbeatAge: prometheus.NewDesc(
+			"spacelift_worker_heartbeat_age_seconds",
+			"The number of seconds since a worker last checked in with Spacelift",
+			workerLabels,
+			nil),
+		workerVersion: prometheus.NewDesc(
+			"spacelift_worker_version_info",
+			"Contains the version of the launcher a worker is running",
+			append(workerLabels, "version"),
+			nil),
+	}
+}
+
+func (c *workerPoolsCollector) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	descriptorChannel <- c.info
+	descriptorChannel <- c.runsPending
+	descriptorChannel <- c.workersBusy
+	descriptorChannel <- c.workers
+	descriptorChannel <- c.workersDrained
+	descriptorChannel <- c.workerBusy
+	descriptorChannel <- c.workerDrained
+	descriptorChannel <- c.workerCreatedAt
+	descriptorChannel <- c.workerHeartbeatAge
+	descriptorChannel <- c.workerVersion
+}
+
+type workerPoolsQuery struct {
+	WorkerPools []struct {
+		ID          string   `graphql:"id"`
+		Name        string   `graphql:"name"`
+		Space       string   `graphql:"space"`
+		PendingRuns int      `graphql:"pendingRuns"`
+		BusyWorkers int      `graphql:"busyWorkers"`
+		Workers     []worker `graphql:"workers"`
+	} `graphql:"workerPools"`
+}
+
+type worker struct {
+	ID        string `graphql:"id"`
+	Busy      bool   `graphql:"busy"`
+	Drained   bool   `graphql:"drained"`
+	Created   int    `graphql:"created"`
+	Handshake *int   `graphql:"handshake"`
+	Metadata  string `graphql:"metadata"`
+}
+
+// workerMetadata is the subset of the metadata a worker registers with that we use
+// for labels. Workers set it with SPACELIFT_METADATA_* environment variables.
+type workerMetadata struct {
+	Hostname   string `json:"hostname"`
+	Version    string `json:"version"`
+	ASGID      string `json:"asg_id"`
+	InstanceID string `json:"instance_id"`
+}
+
+func (w *worker) metadata() workerMetadata {
+	var metadata workerMetadata
+
+	// Metadata is free-form, so a worker without valid metadata simply gets empty
+	// labels.
+	_ = json.Unmarshal([]byte(w.Metadata), &metadata)
+
+	return metadata
+}
+
+func (c *workerPoolsCollector) Collect(ctx context.Context, api client.Client) ([]prometheus.Metric, error) {
+	var query workerPoolsQuery
+	err := api.Query(ctx, &query, nil)
+	if err != nil && !client.IsPartial(err) {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var metrics []prometheus.Metric
+	for _, workerPool := range query.WorkerPools {
+		if workerPool.ID == "" {
+			// The API couldn't resolve this worker pool.
+			continue
+		}
+
+		drained := 0
+		for _, worker := range workerPool.Workers {
+			if worker.Drained {
+				drained++
+			}
+
+			metadata := worker.metadata()
+			labels := []string{workerPool.ID, workerPool.Name, worker.ID, metadata.Hostname, metadata.ASGID, metadata.InstanceID}
+
+			metrics = append(metrics,
+				prometheus.MustNewConstMetric(c.workerBusy, prometheus.GaugeValue, boolToFloat(worker.Busy), labels...),
+				prometheus.MustNewConstMetric(c.workerDrained, prometheus.GaugeValue, boolToFloat(worker.Drained), labels...),
+				prometheus.MustNewConstMetric(c.workerCreatedAt, prometheus.GaugeValue, float64(worker.Created), labels...),
+				prometheus.MustNewConstMetric(c.workerVersion, prometheus.GaugeValue, 1, append(labels, metadata.Version)...),
+			)
+
+			if worker.Handshake != nil {
+				heartbeatAge := now.Sub(time.Unix(int64(*worker.Handshake), 0)).Seconds()
+				metrics = append(metrics, prometheus.MustNewConstMetric(c.workerHeartbeatAge, prometheus.GaugeValue, heartbeatAge, labels...))
+			}
+		}
+
+		poolLabels := []string{workerPool.ID, workerPool.Name}
+
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, append(poolLabels, workerPool.Space)...),
+			prometheus.MustNewConstMetric(c.runsPending, prometheus.GaugeValue, float64(workerPool.PendingRuns), poolLabels...),
+			prometheus.MustNewConstMetric(c.workersBusy, prometheus.GaugeValue, float64(workerPool.BusyWorkers), poolLabels...),
+			prometheus.MustNewConstMetric(c.workers, prometheus.GaugeValue, float64(len(workerPool.Workers)), poolLabels...),
+			prometheus.MustNewConstMetric(c.workersDrained, prometheus.GaugeValue, float64(drained), poolLabels...),
+		)
+	}
+
+	return metrics, err
+}
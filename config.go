@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+// config is the full configuration of the serve command. It's built from the command
+// line flags, with the values set in the --config-file, if any, taking precedence.
+type config struct {
+	ListenAddress      string            `yaml:"listen_address"`
+	WebConfigFile      string            `yaml:"web_config_file"`
+	WebEnableLifecycle bool              `yaml:"web_enable_lifecycle"`
+	IsDevelopment      bool              `yaml:"is_development"`
+	APIEndpoint        string            `yaml:"api_endpoint"`
+	CACertPath         string            `yaml:"ca_cert_path"`
+	APIKeyID           string            `yaml:"api_key_id"`
+	APIKeySecret       string            `yaml:"api_key_secret"`
+	APIKeySecretFile   string            `yaml:"api_key_secret_file"`
+	ScrapeTimeout      time.Duration     `yaml:"scrape_timeout"`
+	PollInterval       time.Duration     `yaml:"poll_interval"`
+	Collectors         []string          `yaml:"collectors"`
+	Retry              retryConfig       `yaml:"retry"`
+	WebhookSecret      string            `yaml:"webhook_secret"`
+	LabelFilters       []*labelFilter    `yaml:"label_filters"`
+	RelabelConfigs     []*relabelConfig  `yaml:"relabel_configs"`
+	Billing            billingConfig     `yaml:"billing"`
+	PushGateway        pushGatewayConfig `yaml:"push_gateway"`
+	RemoteWrite        remoteWriteConfig `yaml:"remote_write"`
+	OTLP               otlpConfig        `yaml:"otlp"`
+	StatsD             statsdConfig      `yaml:"statsd"`
+
+	// Accounts, if set, replace the top-level API settings to scrape several
+	// Spacelift accounts from a single exporter.
+	Accounts []*accountConfig `yaml:"accounts"`
+
+	// Modules hold the credentials for the /probe endpoint, by module name.
+	Modules map[string]*probeModule `yaml:"modules"`
+
+	// collectorNames are the Collectors, resolved by validate.
+	collectorNames []string
+
+	// hash identifies the contents of the configuration file, so that we only reload
+	// it when it has actually changed.
+	hash [sha256.Size]byte
+}
+
+// accountConfig holds the settings to connect to a single Spacelift account.
+type accountConfig struct {
+	Name             string `yaml:"name"`
+	APIEndpoint      string `yaml:"api_endpoint"`
+	CACertPath       string `yaml:"ca_cert_path"`
+	APIKeyID         string `yaml:"api_key_id"`
+	APIKeySecret     string `yaml:"api_key_secret"`
+	APIKeySecretFile string `yaml:"api_key_secret_file"`
+
+	// Billing, if set, replaces the top-level billing settings for this account.
+	Billing *billingConfig `yaml:"billing"`
+}
+
+func (a *accountConfig) validate() error {
+	if a.APIEndpoint == "" {
+		return errors.New("api-endpoint is required")
+	}
+
+	if url, err := url.Parse(a.APIEndpoint); err != nil || url.Scheme == "" || url.Host == "" {
+		return fmt.Errorf("api-endpoint %q does not seem to be a valid URL", a.APIEndpoint)
+	}
+
+	if a.APIKeyID == "" {
+		return errors.New("api-key-id is required")
+	}
+
+	if a.Billing != nil {
+		if err := a.Billing.validate(); err != nil {
+			return fmt.Errorf("billing: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// billingConfig describes an account's Spacelift contract, which the usage collector
+// uses to forecast usage and estimate the cost of the current billing period. All
+// the settings are optional, and prices are in whatever currency the contract uses.
+type billingConfig struct {
+	// IncludedPrivateMinutes and IncludedPublicMinutes are the worker minutes the
+	// contract includes per billing period.
+	IncludedPrivateMinutes float64 `yaml:"included_private_minutes"`
+	IncludedPublicMinutes  float64 `yaml:"included_public_minutes"`
+
+	// PrivateMinutePrice and PublicMinutePrice are the prices of every minute used
+	// beyond the included ones.
+	PrivateMinutePrice float64 `yaml:"private_minute_price"`
+	PublicMinutePrice  float64 `yaml:"public_minute_price"`
+
+	// SeatPrice is the price of every seat used during the billing period.
+	SeatPrice float64 `yaml:"seat_price"`
+
+	// BasePrice is the fixed price of every billing period.
+	BasePrice float64 `yaml:"base_price"`
+}
+
+func (b *billingConfig) validate() error {
+	for name, value := range map[string]float64{
+		"included_private_minutes": b.IncludedPrivateMinutes,
+		"included_public_minutes":  b.IncludedPublicMinutes,
+		"private_minute_price":     b.PrivateMinutePrice,
+		"public_minute_price":      b.PublicMinutePrice,
+		"seat_price":               b.SeatPrice,
+		"base_price":               b.BasePrice,
+	} {
+		if value < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+
+	return nil
+}
+
+// priced returns true if any of the prices are set, so that there's a cost to
+// estimate.
+func (b *billingConfig) priced() bool {
+	return b.PrivateMinutePrice > 0 || b.PublicMinutePrice > 0 || b.SeatPrice > 0 || b.BasePrice > 0
+}
+
+type retryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Jitter         float64       `yaml:"jitter"`
+}
+
+func (r *retryConfig) policy() client.RetryPolicy {
+	return client.RetryPolicy{
+		MaxAttempts:    r.MaxAttempts,
+		InitialBackoff: r.InitialBackoff,
+		MaxBackoff:     r.MaxBackoff,
+		Jitter:         r.Jitter,
+	}
+}
+
+// configFromFlags returns the configuration set by the command line flags and their
+// environment variables.
+func configFromFlags() *config {
+	return &config{
+		ListenAddress:      listenAddress,
+		WebConfigFile:      webConfigFile,
+		WebEnableLifecycle: webEnableLifecycle,
+		IsDevelopment:      isDevelopment,
+		APIEndpoint:        apiEndpoint,
+		CACertPath:         caCertPath,
+		APIKeyID:           apiKeyID,
+		APIKeySecret:       apiKeySecret,
+		APIKeySecretFile:   apiKeySecretFile,
+		ScrapeTimeout:      scrapeTimeout,
+		PollInterval:       pollInterval,
+		Collectors:         collectors,
+		Retry: retryConfig{
+			MaxAttempts:    retryMaxAttempts,
+			InitialBackoff: retryInitialBackoff,
+			MaxBackoff:     retryMaxBackoff,
+			Jitter:         retryJitter,
+		},
+		WebhookSecret: webhookSecret,
+		PushGateway: pushGatewayConfig{
+			URL:      pushGatewayURL,
+			Job:      pushGatewayJob,
+			Grouping: parsePairs(pushGatewayGrouping),
+			Interval: pushGatewayInterval,
+			Username: pushGatewayUsername,
+			Password: pushGatewayPassword,
+		},
+		RemoteWrite: remoteWriteConfig{
+			URL:            remoteWriteURL,
+			Interval:       remoteWriteInterval,
+			ExternalLabels: parsePairs(remoteWriteExternalLabels),
+			Headers:        parsePairs(remoteWriteHeaders),
+			Username:       remoteWriteUsername,
+			Password:       remoteWritePassword,
+			BearerToken:    remoteWriteBearerToken,
+			QueueCapacity:  remoteWriteQueueCapacity,
+		},
+		OTLP: otlpConfig{
+			Endpoint:           otlpEndpoint,
+			Protocol:           otlpProtocol,
+			Interval:           otlpInterval,
+			Headers:            parsePairs(otlpHeaders),
+			ResourceAttributes: parsePairs(otlpResourceAttributes),
+		},
+		StatsD: statsdConfig{
+			Address:  statsdAddress,
+			Interval: statsdInterval,
+			Prefix:   statsdPrefix,
+			Tags:     parsePairs(statsdTags),
+		},
+	}
+}
+
+// loadConfig builds the configuration from the command line flags and the
+// configuration file at path, if set, and validates it.
+func loadConfig(path string) (*config, error) {
+	cfg := configFromFlags()
+
+	if path != "" {
+		data, err := os.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return nil, fmt.Errorf("could not read config file %q: %w", path, err)
+		}
+
+		if err := cfg.overlay(data); err != nil {
+			return nil, fmt.Errorf("could not parse config file %q: %w", path, err)
+		}
+
+		cfg.hash = sha256.Sum256(data)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// overlay replaces the values in the configuration with the ones set in the YAML
+// document. Unknown keys are rejected so that typos don't go unnoticed.
+func (c *config) overlay(data []byte) error {
+	var fromFile config
+	if err := decodeStrict(data, &fromFile); err != nil {
+		return err
+	}
+
+	if err := decodeStrict(data, c); err != nil {
+		return err
+	}
+
+	// The API key secret options are mutually exclusive, so setting one of them in the
+	// file replaces both of the ones set by flags.
+	if fromFile.APIKeySecret != "" || fromFile.APIKeySecretFile != "" {
+		c.APIKeySecret = fromFile.APIKeySecret
+		c.APIKeySecretFile = fromFile.APIKeySecretFile
+	}
+
+	return nil
+}
+
+func decodeStrict(data []byte, out any) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+func (c *config) validate() error {
+	if err := web.Validate(c.WebConfigFile); err != nil {
+		return fmt.Errorf("invalid web config file %q: %w", c.WebConfigFile, err)
+	}
+
+	if err := c.validateAccounts(); err != nil {
+		return err
+	}
+
+	if c.ScrapeTimeout <= 0 {
+		return errors.New("scrape-timeout must be greater than 0")
+	}
+
+	if c.PollInterval < 0 {
+		return errors.New("poll-interval must not be negative")
+	}
+
+	if c.Retry.MaxAttempts < 1 {
+		return errors.New("retry-max-attempts must be at least 1")
+	}
+
+	if c.Retry.InitialBackoff < 0 || c.Retry.MaxBackoff < 0 {
+		return errors.New("retry-initial-backoff and retry-max-backoff must not be negative")
+	}
+
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		return errors.New("retry-jitter must be between 0 and 1")
+	}
+
+	if err := c.Billing.validate(); err != nil {
+		return fmt.Errorf("billing: %w", err)
+	}
+
+	if err := c.PushGateway.validate(); err != nil {
+		return fmt.Errorf("push_gateway: %w", err)
+	}
+
+	if err := c.RemoteWrite.validate(); err != nil {
+		return fmt.Errorf("remote_write: %w", err)
+	}
+
+	if err := c.OTLP.validate(); err != nil {
+		return fmt.Errorf("otlp: %w", err)
+	}
+
+	if err := c.StatsD.validate(); err != nil {
+		return fmt.Errorf("statsd: %w", err)
+	}
+
+	collectorNames, err := resolveSubCollectors(c.Collectors)
+	if err != nil {
+		return err
+	}
+	c.collectorNames = collectorNames
+
+	for name, module := range c.Modules {
+		if err := module.compile(); err != nil {
+			return fmt.Errorf("modules[%s]: %w", name, err)
+		}
+	}
+
+	for i, filter := range c.LabelFilters {
+		if err := filter.compile(); err != nil {
+			return fmt.Errorf("label_filters[%d]: %w", i, err)
+		}
+	}
+
+	for i, relabel := range c.RelabelConfigs {
+		if err := relabel.compile(); err != nil {
+			return fmt.Errorf("relabel_configs[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// accounts returns the Spacelift accounts to scrape. Without an accounts list, that's
+// a single unnamed account using the top-level API settings, unless there's no
+// top-level API endpoint and the exporter is only used through /probe.
+func (c *config) accounts() []*accountConfig {
+	if len(c.Accounts) > 0 {
+		return c.Accounts
+	}
+
+	if c.probeOnly() {
+		return nil
+	}
+
+	return []*accountConfig{{
+		APIEndpoint:      c.APIEndpoint,
+		CACertPath:       c.CACertPath,
+		APIKeyID:         c.APIKeyID,
+		APIKeySecret:     c.APIKeySecret,
+		APIKeySecretFile: c.APIKeySecretFile,
+		Billing:          &c.Billing,
+	}}
+}
+
+func (c *config) probeOnly() bool {
+	return len(c.Accounts) == 0 && c.APIEndpoint == "" && len(c.Modules) > 0
+}
+
+func (c *config) validateAccounts() error {
+	if c.probeOnly() {
+		return nil
+	}
+
+	if len(c.Accounts) == 0 {
+		return c.accounts()[0].validate()
+	}
+
+	if c.APIEndpoint != "" || c.CACertPath != "" || c.APIKeyID != "" || c.APIKeySecret != "" || c.APIKeySecretFile != "" {
+		return errors.New("the top-level API settings can't be combined with accounts, move them to an account instead")
+	}
+
+	names := make(map[string]struct{}, len(c.Accounts))
+	for i, account := range c.Accounts {
+		if account.Name == "" {
+			return fmt.Errorf("accounts[%d]: name is required", i)
+		}
+
+		if _, ok := names[account.Name]; ok {
+			return fmt.Errorf("accounts[%d]: duplicate account name %q", i, account.Name)
+		}
+		names[account.Name] = struct{}{}
+
+		if err := account.validate(); err != nil {
+			return fmt.Errorf("accounts[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
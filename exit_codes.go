@@ -6,4 +6,13 @@ const (
 
 	// ExitCodeStartupError is the exit code when the exporter fails to start correctly.
 	ExitCodeStartupError
+
+	// ExitCodeCollectorError is the exit code of the check command when a collector
+	// fails, even if only for some fields.
+	ExitCodeCollectorError
+
+	// ExitCodeSessionError is the exit code of the check command when the API key of
+	// an account can't be exchanged for a session. It takes precedence over collector
+	// errors in other accounts.
+	ExitCodeSessionError
 )
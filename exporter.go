@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+
+	"github.com/spacelift-io/prometheus-exporter/client/session"
+	"github.com/spacelift-io/prometheus-exporter/logging"
+)
+
+// configWatchInterval is how often we check the configuration file for changes.
+const configWatchInterval = 10 * time.Second
+
+// exporter serves the metrics of the current configuration, and swaps it for a new one
+// when the configuration is reloaded. A configuration that fails to load leaves the
+// previous one in place.
+type exporter struct {
+	ctx        context.Context
+	logger     *zap.SugaredLogger
+	configFile string
+
+	// registry holds the metrics that outlive a single configuration.
+	registry *prometheus.Registry
+	webhooks *webhookReceiver
+
+	reloadMutex sync.Mutex
+	current     atomic.Pointer[pipeline]
+
+	// watchedHash is the hash of the configuration file when watch last looked at it
+	// or it was last applied, so that a file which fails to load is only reported once
+	// per change and a file reloaded by other means isn't reloaded again. It's guarded
+	// by reloadMutex.
+	watchedHash [sha256.Size]byte
+
+	lastReloadSuccessful  prometheus.Gauge
+	lastReloadSuccessTime prometheus.Gauge
+}
+
+// pipeline is everything built from a single configuration.
+type pipeline struct {
+	config   *config
+	cancel   context.CancelFunc
+	accounts []*accountCollector
+	prober   *prober
+	gatherer prometheus.Gatherer
+}
+
+// accountCollector is the collector of a single Spacelift account.
+type accountCollector struct {
+	name      string
+	endpoint  string
+	settings  accountSettings
+	collector *spaceliftCollector
+}
+
+// accountSettings are the settings an account's collector is built with. A reload that
+// leaves them unchanged hands the latest snapshot of the old collector to the new one.
+type accountSettings struct {
+	account       accountConfig
+	billing       billingConfig
+	collectors    string
+	scrapeTimeout time.Duration
+	pollInterval  time.Duration
+	retry         retryConfig
+}
+
+func newAccountSettings(cfg *config, account *accountConfig) accountSettings {
+	settings := accountSettings{
+		account:       *account,
+		billing:       cfg.Billing,
+		collectors:    strings.Join(cfg.collectorNames, ","),
+		scrapeTimeout: cfg.ScrapeTimeout,
+		pollInterval:  cfg.PollInterval,
+		retry:         cfg.Retry,
+	}
+
+	if account.Billing != nil {
+		settings.billing = *account.Billing
+	}
+	settings.account.Billing = nil
+
+	return settings
+}
+
+// newExporter creates the exporter and applies the initial configuration, which has
+// already been loaded and validated.
+func newExporter(ctx context.Context, configFile string, cfg *config) (*exporter, error) {
+	exporter := &exporter{
+		ctx:        ctx,
+		logger:     logging.FromContext(ctx).Sugar(),
+		configFile: configFile,
+		registry:   prometheus.NewRegistry(),
+		webhooks:   newWebhookReceiver(ctx, cfg.WebhookSecret),
+		lastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "spacelift_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful",
+		}),
+		lastReloadSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "spacelift_config_last_reload_success_timestamp_seconds",
+			Help: "The timestamp of the last successful configuration reload",
+		}),
+	}
+	exporter.registry.MustRegister(exporter.lastReloadSuccessful, exporter.lastReloadSuccessTime)
+
+	if err := exporter.apply(cfg); err != nil {
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+// Gather implements prometheus.Gatherer, gathering the metrics of the current
+// configuration.
+func (e *exporter) Gather() ([]*dto.MetricFamily, error) {
+	current := e.current.Load()
+
+	return (&relabelingGatherer{
+		gatherer: prometheus.Gatherers{e.registry, current.gatherer},
+		filters:  current.config.LabelFilters,
+		relabels: current.config.RelabelConfigs,
+	}).Gather()
+}
+
+// reload loads the configuration again and applies it.
+func (e *exporter) reload() error {
+	cfg, err := loadConfig(e.configFile)
+	if err == nil {
+		err = e.apply(cfg)
+	}
+
+	if err != nil {
+		e.lastReloadSuccessful.Set(0)
+		e.logger.Errorw("Failed to reload configuration, keeping the previous one", zap.Error(err))
+
+		return err
+	}
+
+	e.logger.Info("Configuration reloaded")
+
+	return nil
+}
+
+// apply builds a pipeline from the configuration and makes it the current one.
+func (e *exporter) apply(cfg *config) error {
+	e.reloadMutex.Lock()
+	defer e.reloadMutex.Unlock()
+
+	if previous := e.current.Load(); previous != nil {
+		if cfg.ListenAddress != previous.config.ListenAddress {
+			e.logger.Warn("Changing listen_address requires a restart, ignoring the new value")
+		}
+
+		if cfg.WebConfigFile != previous.config.WebConfigFile {
+			e.logger.Warn("Changing web_config_file requires a restart, ignoring the new value")
+		}
+
+		if cfg.IsDevelopment != previous.config.IsDevelopment {
+			e.logger.Warn("Changing is_development requires a restart, ignoring the new value")
+		}
+	}
+
+	next, err := e.build(cfg)
+	if err != nil {
+		return err
+	}
+
+	e.webhooks.setSecret(cfg.WebhookSecret)
+
+	if previous := e.current.Swap(next); previous != nil {
+		previous.cancel()
+	}
+	e.watchedHash = cfg.hash
+
+	e.lastReloadSuccessful.Set(1)
+	e.lastReloadSuccessTime.SetToCurrentTime()
+
+	return nil
+}
+
+func (e *exporter) build(cfg *config) (*pipeline, error) {
+	ctx, cancel := context.WithCancel(e.ctx)
+
+	reg := prometheus.NewRegistry()
+
+	var previous []*accountCollector
+	if current := e.current.Load(); current != nil {
+		previous = current.accounts
+	}
+
+	configs := cfg.accounts()
+	accounts := make([]*accountCollector, 0, len(configs))
+
+	// Every account exchanges its API key for a token, so we build them concurrently
+	// to keep reloads quick with many accounts.
+	collectors := make([]*spaceliftCollector, len(configs))
+	settings := make([]accountSettings, len(configs))
+	errs := make([]error, len(configs))
+
+	var wg sync.WaitGroup
+	for i, account := range configs {
+		settings[i] = newAccountSettings(cfg, account)
+
+		var replaced *spaceliftCollector
+		if j := slices.IndexFunc(previous, func(a *accountCollector) bool { return a.settings == settings[i] }); j >= 0 {
+			replaced = previous[j].collector
+		}
+
+		wg.Go(func() {
+			collectors[i], errs[i] = e.buildAccount(ctx, cfg, account, settings[i], replaced)
+		})
+	}
+	wg.Wait()
+
+	for i, account := range configs {
+		if err := errs[i]; err != nil {
+			cancel()
+
+			if account.Name != "" {
+				return nil, fmt.Errorf("account %q: %w", account.Name, err)
+			}
+
+			return nil, err
+		}
+
+		accountRegisterer(reg, account.Name).MustRegister(collectors[i])
+		accounts = append(accounts, &accountCollector{name: account.Name, endpoint: account.APIEndpoint, settings: settings[i], collector: collectors[i]})
+	}
+
+	if cfg.WebhookSecret != "" {
+		reg.MustRegister(e.webhooks)
+	}
+
+	if cfg.OTLP.Endpoint != "" {
+		if err := e.startOTLP(ctx, cfg, accounts); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	return &pipeline{
+		config:   cfg,
+		cancel:   cancel,
+		accounts: accounts,
+		prober:   newProber(ctx, cfg),
+		gatherer: reg,
+	}, nil
+}
+
+// buildAccount creates the collector of an account. If it replaces a collector built
+// with the same settings, it starts from that collector's latest snapshot.
+func (e *exporter) buildAccount(ctx context.Context, cfg *config, account *accountConfig, settings accountSettings, replaced *spaceliftCollector) (*spaceliftCollector, error) {
+	if account.Name != "" {
+		ctx = logging.WithFields(ctx, zap.String("account", account.Name))
+	}
+	logger := logging.FromContext(ctx).Sugar()
+
+	secretProvider, err := buildSecretProvider(account.APIKeySecret, account.APIKeySecretFile)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClient(account.CACertPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure HTTP client: %w", err)
+	}
+
+	accountSession, err := func() (session.Session, error) {
+		sessionCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+		return session.NewWithSecretProvider(sessionCtx, httpClient, account.APIEndpoint, account.APIKeyID, secretProvider)
+	}()
+	if err != nil && account.Name == "" {
+		return nil, err
+	}
+
+	if err != nil {
+		// One unreachable account mustn't stop the others from being scraped, so we
+		// keep trying to exchange its API key on every scrape instead, and report it
+		// as down until that works.
+		logger.Errorw("Could not create Spacelift API session, will retry on the next scrape", zap.Error(err))
+
+		if accountSession, err = session.LazyFromAPIKeyProvider(httpClient, account.APIEndpoint, account.APIKeyID, secretProvider); err != nil {
+			return nil, err
+		}
+	} else {
+		logger.Info("Successfully created Spacelift API session")
+	}
+
+	collector, err := newSpaceliftCollector(ctx, httpClient, accountSession, collectorOptions{
+		scrapeTimeout: cfg.ScrapeTimeout,
+		pollInterval:  cfg.PollInterval,
+		collectors:    cfg.collectorNames,
+		retryPolicy:   cfg.Retry.policy(),
+		billing:       settings.billing,
+		replaced:      replaced,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create Spacelift collector: %w", err)
+	}
+
+	return collector, nil
+}
+
+// accountRegisterer adds the account label to every metric registered through it,
+// unless the account is the unnamed one used without an accounts list.
+func accountRegisterer(reg prometheus.Registerer, account string) prometheus.Registerer {
+	if account == "" {
+		return reg
+	}
+
+	return prometheus.WrapRegistererWith(prometheus.Labels{"account": account}, reg)
+}
+
+// metricsHandler serves the metrics of the current configuration. If the request has
+// collect[] parameters, only the metrics of the selected collectors are served instead.
+func (e *exporter) metricsHandler() http.Handler {
+	opts := promhttp.HandlerOpts{
+		// Opt into OpenMetrics to support exemplars.
+		EnableOpenMetrics: true,
+	}
+	handler := promhttp.HandlerFor(e, opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["collect[]"]
+		if len(names) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		current := e.current.Load()
+
+		restrictedReg := prometheus.NewRegistry()
+		for _, account := range current.accounts {
+			restricted, err := account.collector.restrictedTo(names)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			accountRegisterer(restrictedReg, account.name).MustRegister(restricted)
+		}
+
+		promhttp.HandlerFor(&relabelingGatherer{
+			gatherer: restrictedReg,
+			filters:  current.config.LabelFilters,
+			relabels: current.config.RelabelConfigs,
+		}, opts).ServeHTTP(w, r)
+	})
+}
+
+// probeHandler runs the collectors against the target and module in the query, in
+// the same way as the blackbox exporter's /probe endpoint.
+func (e *exporter) probeHandler() http.Handler {
+	opts := promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, module := r.URL.Query().Get("target"), r.URL.Query().Get("module")
+		if target == "" || module == "" {
+			http.Error(w, "the target and module parameters are required", http.StatusBadRequest)
+			return
+		}
+
+		current := e.current.Load()
+
+		collector, err := current.prober.collector(target, module)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		probeReg := prometheus.NewRegistry()
+		probeReg.MustRegister(collector)
+
+		promhttp.HandlerFor(&relabelingGatherer{
+			gatherer: probeReg,
+			filters:  current.config.LabelFilters,
+			relabels: current.config.RelabelConfigs,
+		}, opts).ServeHTTP(w, r)
+	})
+}
+
+// reloadHandler reloads the configuration on POST or PUT requests, in the same way as
+// Prometheus' /-/reload endpoint. Like in Prometheus, it has to be enabled first,
+// since every reload exchanges the API keys again and restarts the senders.
+func (e *exporter) reloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !e.current.Load().config.WebEnableLifecycle {
+			http.Error(w, "Lifecycle API is not enabled.", http.StatusForbidden)
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := e.reload(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("Configuration reloaded\n"))
+	})
+}
+
+// watch reloads the configuration on SIGHUP and whenever the configuration file
+// changes, until ctx is cancelled.
+func (e *exporter) watch(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			e.logger.Info("Received SIGHUP - reloading configuration")
+			_ = e.reload()
+		case <-ticker.C:
+			if e.configFileChanged() {
+				e.logger.Info("Configuration file changed - reloading configuration")
+				_ = e.reload()
+			}
+		}
+	}
+}
+
+func (e *exporter) configFileChanged() bool {
+	if e.configFile == "" {
+		return false
+	}
+
+	data, err := os.ReadFile(filepath.Clean(e.configFile))
+	if err != nil {
+		// Reloading would fail just the same, so we leave it to the next SIGHUP or
+		// change to report the error.
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+
+	e.reloadMutex.Lock()
+	defer e.reloadMutex.Unlock()
+
+	if hash == e.watchedHash {
+		return false
+	}
+	e.watchedHash = hash
+
+	return true
+}
+
+// intervalSender sends the exporter's metrics somewhere on an interval, with settings
+// taken from the current configuration.
+type intervalSender[T any] struct {
+	// settings returns the sender's settings from a configuration, or nil if they
+	// don't enable it.
+	settings func(*config) *T
+	interval func(*T) time.Duration
+	send     func(context.Context, *T)
+
+	// disabled, if set, is called instead of send while the sender isn't enabled.
+	disabled func()
+}
+
+// run calls send on the sender's interval until ctx is cancelled. The settings are
+// read again before every call, so that reloading the configuration can change them,
+// and checked every configWatchInterval while they don't enable the sender.
+func (s *intervalSender[T]) run(ctx context.Context, e *exporter) {
+	for {
+		wait := configWatchInterval
+
+		if settings := s.settings(e.current.Load().config); settings == nil {
+			if s.disabled != nil {
+				s.disabled()
+			}
+		} else {
+			s.send(ctx, settings)
+			wait = s.interval(settings)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// senderMetrics are the metrics a sender reports about itself. They're registered
+// with the exporter the first time the sender sends anything, so that they're only
+// exported once it's enabled.
+type senderMetrics struct {
+	once       sync.Once
+	registry   *prometheus.Registry
+	collectors []prometheus.Collector
+}
+
+func newSenderMetrics(exporter *exporter, collectors ...prometheus.Collector) *senderMetrics {
+	return &senderMetrics{registry: exporter.registry, collectors: collectors}
+}
+
+func (m *senderMetrics) register() {
+	m.once.Do(func() {
+		m.registry.MustRegister(m.collectors...)
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spacelift-io/prometheus-exporter/logging"
+)
+
+// probeOnlyConfig is a configuration without accounts, so that applying it doesn't
+// need the Spacelift API.
+const probeOnlyConfig = `
+scrape_timeout: 5s
+retry:
+  max_attempts: 1
+modules:
+  default:
+    api_key_id: id
+    api_key_secret: secret
+`
+
+func TestManualReloadUpdatesWatchedHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(probeOnlyConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(logging.Init(context.Background(), true))
+	t.Cleanup(cancel)
+
+	exporter, err := newExporter(ctx, path, cfg)
+	if err != nil {
+		t.Fatalf("could not create exporter: %v", err)
+	}
+
+	if exporter.configFileChanged() {
+		t.Error("the configuration file counts as changed right after it was applied")
+	}
+
+	if err := os.WriteFile(path, []byte(probeOnlyConfig+"poll_interval: 1m\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// As on SIGHUP or a POST to /-/reload.
+	if err := exporter.reload(); err != nil {
+		t.Fatalf("could not reload: %v", err)
+	}
+
+	if exporter.configFileChanged() {
+		t.Error("the file watcher would reload the configuration again after a manual reload")
+	}
+}
@@ -36,3 +36,8 @@ func FromContext(ctx context.Context) *zap.Logger {
 
 	return defaultLogger
 }
+
+// WithFields returns a new context whose logger adds the fields to every entry.
+func WithFields(ctx context.Context, fields ...zap.Field) context.Context {
+	return context.WithValue(ctx, loggerKey, FromContext(ctx).With(fields...))
+}
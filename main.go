@@ -19,7 +19,7 @@ func main() {
 	app := &cli.Command{
 		Name:      "spacelift-promex",
 		Usage:     "Exports metrics from your Spacelift account to Prometheus",
-		Commands:  []*cli.Command{serveCommand},
+		Commands:  []*cli.Command{serveCommand, checkCommand},
 		Version:   fmt.Sprintf("%s - %s", version, commit),
 		Copyright: fmt.Sprintf("Copyright (c) %d spacelift-io", time.Now().Year()),
 	}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	otelprometheus "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.uber.org/zap"
+)
+
+const (
+	otlpProtocolGRPC = "grpc"
+	otlpProtocolHTTP = "http/protobuf"
+
+	// otlpShutdownTimeout bounds the final export when a configuration is replaced.
+	otlpShutdownTimeout = 5 * time.Second
+)
+
+// otlpConfig holds the settings to export metrics to an OpenTelemetry collector over
+// OTLP. Exporting is disabled without an endpoint.
+type otlpConfig struct {
+	Endpoint           string            `yaml:"endpoint"`
+	Protocol           string            `yaml:"protocol"`
+	Interval           time.Duration     `yaml:"interval"`
+	Headers            map[string]string `yaml:"headers"`
+	ResourceAttributes map[string]string `yaml:"resource_attributes"`
+}
+
+func (o *otlpConfig) validate() error {
+	if o.Endpoint == "" {
+		return nil
+	}
+
+	if url, err := url.Parse(o.Endpoint); err != nil || url.Scheme == "" || url.Host == "" {
+		return fmt.Errorf("endpoint %q does not seem to be a valid URL", o.Endpoint)
+	}
+
+	if o.Protocol != otlpProtocolGRPC && o.Protocol != otlpProtocolHTTP {
+		return fmt.Errorf("unknown protocol %q, must be %s or %s", o.Protocol, otlpProtocolGRPC, otlpProtocolHTTP)
+	}
+
+	if o.Interval <= 0 {
+		return errors.New("interval must be greater than 0")
+	}
+
+	return nil
+}
+
+var otlpErrorHandlerOnce sync.Once
+
+// startOTLP exports the metrics of every account through its own meter provider, so
+// that the account can be described by resource attributes rather than a label. The
+// exporter's own metrics are exported by an additional provider without account
+// attributes. Everything is shut down once ctx is cancelled.
+func (e *exporter) startOTLP(ctx context.Context, cfg *config, accounts []*accountCollector) error {
+	// Failed exports are reported through OpenTelemetry's global error handler.
+	otlpErrorHandlerOnce.Do(func() {
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			e.logger.Errorw("Failed to export metrics over OTLP", zap.Error(err))
+		}))
+	})
+
+	var providers []*sdkmetric.MeterProvider
+	start := func(gatherer prometheus.Gatherer, attributes ...attribute.KeyValue) error {
+		provider, err := newOTLPMeterProvider(ctx, &cfg.OTLP, gatherer, attributes)
+		if err != nil {
+			return err
+		}
+		providers = append(providers, provider)
+
+		return nil
+	}
+
+	shutdown := func() {
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), otlpShutdownTimeout)
+		defer cancel()
+
+		for _, provider := range providers {
+			_ = provider.Shutdown(shutdownCtx)
+		}
+	}
+
+	for _, account := range accounts {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(account.collector)
+
+		attributes := []attribute.KeyValue{attribute.String("spacelift.api_endpoint", account.endpoint)}
+		if account.name != "" {
+			attributes = append(attributes, attribute.String("spacelift.account", account.name))
+		}
+
+		if err := start(&relabelingGatherer{gatherer: reg, filters: cfg.LabelFilters, relabels: cfg.RelabelConfigs}, attributes...); err != nil {
+			shutdown()
+			return err
+		}
+	}
+
+	exporterGatherers := prometheus.Gatherers{e.registry}
+	if cfg.WebhookSecret != "" {
+		webhooksReg := prometheus.NewRegistry()
+		webhooksReg.MustRegister(e.webhooks)
+		exporterGatherers = append(exporterGatherers, webhooksReg)
+	}
+
+	if err := start(&relabelingGatherer{gatherer: exporterGatherers, filters: cfg.LabelFilters, relabels: cfg.RelabelConfigs}); err != nil {
+		shutdown()
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdown()
+	}()
+
+	return nil
+}
+
+// newOTLPMeterProvider creates a meter provider that periodically exports everything
+// the gatherer collects.
+func newOTLPMeterProvider(ctx context.Context, settings *otlpConfig, gatherer prometheus.Gatherer, attributes []attribute.KeyValue) (*sdkmetric.MeterProvider, error) {
+	var exporter sdkmetric.Exporter
+	var err error
+
+	switch settings.Protocol {
+	case otlpProtocolGRPC:
+		exporter, err = otlpmetricgrpc.New(ctx,
+			otlpmetricgrpc.WithEndpointURL(settings.Endpoint),
+			otlpmetricgrpc.WithHeaders(settings.Headers),
+		)
+	case otlpProtocolHTTP:
+		endpoint, _ := url.Parse(settings.Endpoint)
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = "/v1/metrics"
+		}
+
+		exporter, err = otlpmetrichttp.New(ctx,
+			otlpmetrichttp.WithEndpointURL(endpoint.String()),
+			otlpmetrichttp.WithHeaders(settings.Headers),
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create OTLP exporter: %w", err)
+	}
+
+	attributes = append(attributes,
+		semconv.ServiceName("spacelift-promex"),
+		semconv.ServiceVersion(version),
+		attribute.String("spacelift.exporter.commit", commit),
+	)
+	for name, value := range settings.ResourceAttributes {
+		attributes = append(attributes, attribute.String(name, value))
+	}
+
+	// The last of duplicate attributes wins, so the configured ones override ours.
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attributes...))
+	if err != nil {
+		return nil, fmt.Errorf("could not create OTLP resource: %w", err)
+	}
+
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(settings.Interval),
+		sdkmetric.WithProducer(otelprometheus.NewMetricProducer(otelprometheus.WithGatherer(gatherer))),
+	)
+
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(res),
+	), nil
+}
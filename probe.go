@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/spacelift-io/prometheus-exporter/client/session"
+	"github.com/spacelift-io/prometheus-exporter/logging"
+)
+
+const (
+	// defaultProbeAllowedEndpoints only lets probes send credentials to Spacelift's own
+	// SaaS endpoints, unless a module says otherwise.
+	defaultProbeAllowedEndpoints = `https://[^/]+\.spacelift\.io`
+
+	// probeTargetTTL is how long we keep the session and collector of a target that
+	// isn't being probed any more.
+	probeTargetTTL = time.Hour
+)
+
+// probeModule holds the credentials used to probe a target, in the same way as a
+// blackbox exporter module.
+type probeModule struct {
+	APIKeyID         string  `yaml:"api_key_id"`
+	APIKeySecret     string  `yaml:"api_key_secret"`
+	APIKeySecretFile string  `yaml:"api_key_secret_file"`
+	CACertPath       string  `yaml:"ca_cert_path"`
+	AllowedEndpoints *string `yaml:"allowed_endpoints"`
+
+	allowedEndpoints *regexp.Regexp
+}
+
+func (m *probeModule) compile() error {
+	if m.APIKeyID == "" {
+		return errors.New("api_key_id is required")
+	}
+
+	if (m.APIKeySecret == "") == (m.APIKeySecretFile == "") {
+		return errors.New("exactly one of api_key_secret and api_key_secret_file is required")
+	}
+
+	if m.AllowedEndpoints == nil {
+		m.AllowedEndpoints = new(defaultProbeAllowedEndpoints)
+	}
+
+	regex, err := compileAnchored(*m.AllowedEndpoints)
+	if err != nil {
+		return fmt.Errorf("allowed_endpoints: %w", err)
+	}
+	m.allowedEndpoints = regex
+
+	return nil
+}
+
+// probeEndpoint turns a probe target into the API endpoint of the account. Targets
+// can be a full URL, a hostname or just the name of a Spacelift SaaS account.
+// Credentials, queries and fragments are rejected, since they make it easy to hide
+// the actual host from the allowed endpoints regex.
+func probeEndpoint(target string) (*url.URL, error) {
+	switch {
+	case strings.Contains(target, "://"):
+	case strings.Contains(target, "."):
+		target = "https://" + target
+	default:
+		target = fmt.Sprintf("https://%s.app.spacelift.io", target)
+	}
+
+	endpoint, err := url.Parse(target)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" || endpoint.Opaque != "" {
+		return nil, fmt.Errorf("target %q does not seem to be a valid URL", target)
+	}
+
+	if endpoint.User != nil || endpoint.RawQuery != "" || endpoint.ForceQuery || strings.Contains(target, "#") {
+		return nil, fmt.Errorf("target %q must not contain credentials, a query or a fragment", target)
+	}
+
+	endpoint.Path = strings.TrimRight(endpoint.Path, "/")
+	endpoint.RawPath = ""
+
+	return endpoint, nil
+}
+
+// allows tells whether the module may send its credentials to the endpoint. Only the
+// scheme and host are matched, so that nothing else in the URL can pass for a host.
+func (m *probeModule) allows(endpoint *url.URL) bool {
+	return m.allowedEndpoints.MatchString(endpoint.Scheme + "://" + endpoint.Host)
+}
+
+type probeKey struct {
+	endpoint string
+	module   string
+}
+
+type probeTarget struct {
+	collector *spaceliftCollector
+	usedAt    time.Time
+}
+
+// prober keeps a session and collector for every target and module that's been
+// probed, so that probes don't have to exchange the API key every time and the
+// collector's counters keep going up between probes.
+type prober struct {
+	ctx    context.Context
+	config *config
+
+	mutex   sync.Mutex
+	targets map[probeKey]*probeTarget
+}
+
+func newProber(ctx context.Context, cfg *config) *prober {
+	return &prober{
+		ctx:     ctx,
+		config:  cfg,
+		targets: make(map[probeKey]*probeTarget),
+	}
+}
+
+// collector returns the collector for the target probed with the module, creating it
+// if needed.
+func (p *prober) collector(target, moduleName string) (*spaceliftCollector, error) {
+	module, ok := p.config.Modules[moduleName]
+	if !ok {
+		return nil, fmt.Errorf("unknown module %q", moduleName)
+	}
+
+	parsed, err := probeEndpoint(target)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := parsed.String()
+	if !module.allows(parsed) {
+		return nil, fmt.Errorf("module %q is not allowed to probe %q", moduleName, endpoint)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for key, probed := range p.targets {
+		if now.Sub(probed.usedAt) > probeTargetTTL {
+			delete(p.targets, key)
+		}
+	}
+
+	key := probeKey{endpoint: endpoint, module: moduleName}
+	if probed, ok := p.targets[key]; ok {
+		probed.usedAt = now
+		return probed.collector, nil
+	}
+
+	collector, err := p.newCollector(endpoint, module)
+	if err != nil {
+		return nil, err
+	}
+
+	p.targets[key] = &probeTarget{collector: collector, usedAt: now}
+
+	return collector, nil
+}
+
+func (p *prober) newCollector(endpoint string, module *probeModule) (*spaceliftCollector, error) {
+	secretProvider, err := buildSecretProvider(module.APIKeySecret, module.APIKeySecretFile)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClient(module.CACertPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure HTTP client: %w", err)
+	}
+
+	// The API key is only exchanged on the first probe, so that a target that's down
+	// shows up as spacelift_up 0 rather than as a failed probe.
+	probeSession, err := session.LazyFromAPIKeyProvider(httpClient, endpoint, module.APIKeyID, secretProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := logging.WithFields(p.ctx, zap.String("target", endpoint))
+
+	// Probes are always run on demand, so we never poll in the background.
+	return newSpaceliftCollector(ctx, httpClient, probeSession, collectorOptions{
+		scrapeTimeout: p.config.ScrapeTimeout,
+		collectors:    p.config.collectorNames,
+		retryPolicy:   p.config.Retry.policy(),
+	})
+}
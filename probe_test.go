@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProbeModuleAllowedEndpoints(t *testing.T) {
+	module := &probeModule{APIKeyID: "id", APIKeySecret: "secret"}
+	if err := module.compile(); err != nil {
+		t.Fatalf("could not compile module: %v", err)
+	}
+
+	for target, want := range map[string]string{
+		"acme":                             "https://acme.app.spacelift.io",
+		"acme.app.spacelift.io":            "https://acme.app.spacelift.io",
+		"https://acme.app.spacelift.io/":   "https://acme.app.spacelift.io",
+		"https://acme.app.us.spacelift.io": "https://acme.app.us.spacelift.io",
+	} {
+		endpoint, err := probeEndpoint(target)
+		if err != nil {
+			t.Errorf("probeEndpoint(%q) failed: %v", target, err)
+			continue
+		}
+
+		if got := endpoint.String(); got != want {
+			t.Errorf("probeEndpoint(%q) = %q, want %q", target, got, want)
+		}
+
+		if !module.allows(endpoint) {
+			t.Errorf("module does not allow %q", target)
+		}
+	}
+
+	for _, target := range []string{
+		"https://evil.example?.spacelift.io",
+		"https://evil.example#.spacelift.io",
+		"https://a.spacelift.io@evil.example:443?x.spacelift.io",
+		"https://a.spacelift.io@evil.example",
+		"https://evil.example/.spacelift.io",
+		"https://evil.example\\.spacelift.io",
+		"https://evil.example?",
+		"http://acme.app.spacelift.io",
+		"evil.example",
+	} {
+		endpoint, err := probeEndpoint(target)
+		if err == nil && module.allows(endpoint) {
+			t.Errorf("module allows %q, which resolves to host %q", target, endpoint.Host)
+		}
+	}
+}
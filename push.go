@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"go.uber.org/zap"
+)
+
+// pushGatewayConfig holds the settings to push metrics to a Prometheus Pushgateway.
+// Pushing is disabled without a URL.
+type pushGatewayConfig struct {
+	URL      string            `yaml:"url"`
+	Job      string            `yaml:"job"`
+	Grouping map[string]string `yaml:"grouping"`
+	Interval time.Duration     `yaml:"interval"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+}
+
+func (p *pushGatewayConfig) validate() error {
+	if p.URL == "" {
+		return nil
+	}
+
+	if url, err := url.Parse(p.URL); err != nil || url.Scheme == "" || url.Host == "" {
+		return fmt.Errorf("url %q does not seem to be a valid URL", p.URL)
+	}
+
+	if p.Job == "" {
+		return errors.New("job is required")
+	}
+
+	if p.Interval <= 0 {
+		return errors.New("interval must be greater than 0")
+	}
+
+	for label, value := range p.Grouping {
+		if label == "" || value == "" {
+			return fmt.Errorf("grouping label %q must have a name and a value", label)
+		}
+	}
+
+	return nil
+}
+
+// parsePairs turns name=value flag values, such as grouping labels, into a map.
+// Pairs without a value are kept with an empty one, for validation to reject.
+func parsePairs(pairs []string) map[string]string {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	parsed := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		name, value, _ := strings.Cut(pair, "=")
+		parsed[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return parsed
+}
+
+// pusher pushes the exporter's metrics to the Pushgateway of the current
+// configuration, if any, on its interval. The outcome of every push is exported with
+// the metrics, so a failed push shows up with the next successful one.
+type pusher struct {
+	exporter *exporter
+	client   *http.Client
+	metrics  *senderMetrics
+
+	lastPushSuccessful  prometheus.Gauge
+	lastPushSuccessTime prometheus.Gauge
+	pushFailures        prometheus.Counter
+}
+
+func newPusher(exporter *exporter) *pusher {
+	p := &pusher{
+		exporter: exporter,
+		client:   &http.Client{},
+		lastPushSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "spacelift_push_gateway_last_push_successful",
+			Help: "Whether the last push to the Pushgateway was successful",
+		}),
+		lastPushSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "spacelift_push_gateway_last_push_success_timestamp_seconds",
+			Help: "The timestamp of the last successful push to the Pushgateway",
+		}),
+		pushFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spacelift_push_gateway_push_failures_total",
+			Help: "The number of failed pushes to the Pushgateway",
+		}),
+	}
+	p.metrics = newSenderMetrics(exporter, p.lastPushSuccessful, p.lastPushSuccessTime, p.pushFailures)
+
+	return p
+}
+
+// run pushes metrics until ctx is cancelled.
+func (p *pusher) run(ctx context.Context) {
+	(&intervalSender[pushGatewayConfig]{
+		settings: func(cfg *config) *pushGatewayConfig {
+			if cfg.PushGateway.URL == "" {
+				return nil
+			}
+
+			return &cfg.PushGateway
+		},
+		interval: func(settings *pushGatewayConfig) time.Duration { return settings.Interval },
+		send:     p.push,
+	}).run(ctx, p.exporter)
+}
+
+func (p *pusher) push(ctx context.Context, settings *pushGatewayConfig) {
+	p.metrics.register()
+
+	ctx, cancel := context.WithTimeout(ctx, settings.Interval)
+	defer cancel()
+
+	pusher := push.New(settings.URL, settings.Job).Gatherer(p.exporter).Client(p.client)
+	for label, value := range settings.Grouping {
+		pusher = pusher.Grouping(label, value)
+	}
+
+	if settings.Username != "" || settings.Password != "" {
+		pusher = pusher.BasicAuth(settings.Username, settings.Password)
+	}
+
+	// Push replaces all the metrics of the grouping key, so that series which are
+	// gone from the Spacelift account don't linger on the Pushgateway.
+	if err := pusher.PushContext(ctx); err != nil {
+		p.exporter.logger.Errorw("Failed to push metrics to the Pushgateway", "url", settings.URL, zap.Error(err))
+		p.lastPushSuccessful.Set(0)
+		p.pushFailures.Inc()
+
+		return
+	}
+
+	p.lastPushSuccessful.Set(1)
+	p.lastPushSuccessTime.SetToCurrentTime()
+}
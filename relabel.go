@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+const metricNameLabel = "__name__"
+
+var (
+	labelNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+)
+
+// labelFilter keeps or drops series depending on the value of a single label. Series
+// that don't have the label are always kept.
+type labelFilter struct {
+	Label  string `yaml:"label"`
+	Regex  string `yaml:"regex"`
+	Action string `yaml:"action"`
+
+	regex *regexp.Regexp
+}
+
+func (f *labelFilter) compile() error {
+	if f.Label == "" {
+		return fmt.Errorf("label is required")
+	}
+
+	if !labelNameRegex.MatchString(f.Label) {
+		return fmt.Errorf("invalid label name %q", f.Label)
+	}
+
+	switch f.Action {
+	case "":
+		f.Action = "keep"
+	case "keep", "drop":
+	default:
+		return fmt.Errorf("unknown action %q, must be keep or drop", f.Action)
+	}
+
+	regex, err := compileAnchored(f.Regex)
+	if err != nil {
+		return err
+	}
+	f.regex = regex
+
+	return nil
+}
+
+func (f *labelFilter) keeps(labels map[string]string) bool {
+	value, ok := labels[f.Label]
+	if !ok {
+		return true
+	}
+
+	return f.regex.MatchString(value) == (f.Action == "keep")
+}
+
+// relabelConfig is a subset of Prometheus' metric_relabel_configs, supporting the
+// replace, keep, drop, labeldrop and labelkeep actions.
+type relabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    *string  `yaml:"separator"`
+	Regex        *string  `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+
+	regex *regexp.Regexp
+}
+
+func (r *relabelConfig) compile() error {
+	if r.Separator == nil {
+		r.Separator = new(";")
+	}
+
+	if r.Regex == nil {
+		r.Regex = new("(.*)")
+	}
+
+	if r.Replacement == nil {
+		r.Replacement = new("$1")
+	}
+
+	switch r.Action {
+	case "":
+		r.Action = "replace"
+		fallthrough
+	case "replace":
+		if r.TargetLabel == "" {
+			return fmt.Errorf("target_label is required for the replace action")
+		}
+
+		if !labelNameRegex.MatchString(r.TargetLabel) {
+			return fmt.Errorf("invalid target_label %q", r.TargetLabel)
+		}
+	case "keep", "drop":
+		if len(r.SourceLabels) == 0 {
+			return fmt.Errorf("source_labels are required for the %s action", r.Action)
+		}
+	case "labeldrop", "labelkeep":
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	regex, err := compileAnchored(*r.Regex)
+	if err != nil {
+		return err
+	}
+	r.regex = regex
+
+	return nil
+}
+
+// apply relabels the series in place, returning false if it should be dropped.
+func (r *relabelConfig) apply(labels map[string]string) bool {
+	values := make([]string, 0, len(r.SourceLabels))
+	for _, name := range r.SourceLabels {
+		values = append(values, labels[name])
+	}
+	value := strings.Join(values, *r.Separator)
+
+	switch r.Action {
+	case "keep":
+		return r.regex.MatchString(value)
+	case "drop":
+		return !r.regex.MatchString(value)
+	case "labeldrop", "labelkeep":
+		for name := range labels {
+			if name != metricNameLabel && r.regex.MatchString(name) == (r.Action == "labeldrop") {
+				delete(labels, name)
+			}
+		}
+	default:
+		match := r.regex.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+
+		result := string(r.regex.ExpandString(nil, *r.Replacement, value, match))
+		if result == "" {
+			delete(labels, r.TargetLabel)
+		} else {
+			labels[r.TargetLabel] = result
+		}
+	}
+
+	return true
+}
+
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", expr, err)
+	}
+
+	return regex, nil
+}
+
+// relabelingGatherer applies label filters and relabel configs to everything gathered
+// by the wrapped gatherer.
+type relabelingGatherer struct {
+	gatherer prometheus.Gatherer
+	filters  []*labelFilter
+	relabels []*relabelConfig
+}
+
+func (g *relabelingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	if len(g.filters) == 0 && len(g.relabels) == 0 {
+		return families, err
+	}
+
+	// Relabelling can rename series, so we regroup them into families by name. It can
+	// also make series collide, by dropping the only label that told them apart or by
+	// renaming them to the name of another. Like Prometheus does with duplicate samples,
+	// we keep the first of them and drop the others, along with series renamed into a
+	// family of a different type.
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	seen := make(map[string]bool)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			name, ok := g.relabel(family.GetName(), metric)
+			if !ok {
+				continue
+			}
+
+			out, exists := byName[name]
+			if exists && out.GetType() != family.GetType() {
+				continue
+			}
+
+			key := seriesKey(name, metric.Label)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if !exists {
+				out = &dto.MetricFamily{
+					Name: proto.String(name),
+					Help: family.Help,
+					Type: family.Type,
+					Unit: family.Unit,
+				}
+				byName[name] = out
+			}
+
+			out.Metric = append(out.Metric, metric)
+		}
+	}
+
+	relabelled := make([]*dto.MetricFamily, 0, len(byName))
+	for _, family := range byName {
+		relabelled = append(relabelled, family)
+	}
+
+	slices.SortFunc(relabelled, func(a, b *dto.MetricFamily) int {
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+
+	return relabelled, err
+}
+
+// relabel applies the filters and relabel configs to a single series, updating its
+// labels in place. It returns the new name of the series, or false if it was dropped.
+func (g *relabelingGatherer) relabel(name string, metric *dto.Metric) (string, bool) {
+	labels := make(map[string]string, len(metric.Label)+1)
+	labels[metricNameLabel] = name
+	for _, pair := range metric.Label {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+
+	for _, filter := range g.filters {
+		if !filter.keeps(labels) {
+			return "", false
+		}
+	}
+
+	for _, relabel := range g.relabels {
+		if !relabel.apply(labels) {
+			return "", false
+		}
+	}
+
+	// Prometheus would reject a series renamed to something that isn't a metric name.
+	name = labels[metricNameLabel]
+	if !metricNameRegex.MatchString(name) {
+		return "", false
+	}
+	delete(labels, metricNameLabel)
+
+	metric.Label = make([]*dto.LabelPair, 0, len(labels))
+	for _, labelName := range slices.Sorted(maps.Keys(labels)) {
+		metric.Label = append(metric.Label, &dto.LabelPair{
+			Name:  proto.String(labelName),
+			Value: proto.String(labels[labelName]),
+		})
+	}
+
+	return name, true
+}
+
+// seriesKey identifies a series by its name and labels, which must be sorted by name.
+func seriesKey(name string, labels []*dto.LabelPair) string {
+	var key strings.Builder
+	key.WriteString(name)
+	for _, pair := range labels {
+		key.WriteByte(0xff)
+		key.WriteString(pair.GetName())
+		key.WriteByte(0xff)
+		key.WriteString(pair.GetValue())
+	}
+
+	return key.String()
+}
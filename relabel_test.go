@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func compileRelabelConfigs(t *testing.T, relabels ...*relabelConfig) []*relabelConfig {
+	t.Helper()
+
+	for _, relabel := range relabels {
+		if err := relabel.compile(); err != nil {
+			t.Fatalf("could not compile relabel config: %v", err)
+		}
+	}
+
+	return relabels
+}
+
+func TestRelabelDropsCollidingSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	requests := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_requests", Help: "Requests"}, []string{"zone", "host"})
+	requests.WithLabelValues("a", "one").Set(1)
+	requests.WithLabelValues("a", "two").Set(2)
+	requests.WithLabelValues("b", "one").Set(3)
+	reg.MustRegister(requests)
+
+	// Gathered after the gauge, so the gauge is the one kept.
+	other := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_stray_total", Help: "Stray", ConstLabels: prometheus.Labels{"zone": "a"}})
+	other.Add(4)
+	reg.MustRegister(other)
+
+	gatherer := &relabelingGatherer{
+		gatherer: reg,
+		relabels: compileRelabelConfigs(t,
+			// Without the host label, both series of zone a collide.
+			&relabelConfig{Regex: new("host"), Action: "labeldrop"},
+			// The counter renamed to the gauge's name collides with it too.
+			&relabelConfig{SourceLabels: []string{"__name__"}, Regex: new("test_stray_total"), TargetLabel: "__name__", Replacement: new("test_requests")},
+		),
+	}
+
+	// Gathering through Gatherers checks the result for duplicate series.
+	families, err := prometheus.Gatherers{gatherer}.Gather()
+	if err != nil {
+		t.Fatalf("could not gather: %v", err)
+	}
+
+	if len(families) != 1 || families[0].GetName() != "test_requests" || families[0].GetType() != dto.MetricType_GAUGE {
+		t.Fatalf("got families %v, want the test_requests gauge alone", families)
+	}
+
+	values := make(map[string]float64)
+	for _, metric := range families[0].Metric {
+		if len(metric.Label) != 1 || metric.Label[0].GetName() != "zone" {
+			t.Fatalf("got labels %v, want zone only", metric.Label)
+		}
+		values[metric.Label[0].GetValue()] = metric.GetGauge().GetValue()
+	}
+
+	if len(values) != 2 || values["a"] != 1 || values["b"] != 3 {
+		t.Errorf("got values %v, want the first series of each zone", values)
+	}
+}
+
+func TestRelabelConfigValidatesTargetLabel(t *testing.T) {
+	for _, target := range []string{"bad-label", "1st", "with space", "${1}"} {
+		relabel := &relabelConfig{SourceLabels: []string{"zone"}, TargetLabel: target}
+		if err := relabel.compile(); err == nil {
+			t.Errorf("target_label %q was accepted", target)
+		}
+	}
+
+	for _, target := range []string{"zone", "_zone", "__name__"} {
+		relabel := &relabelConfig{SourceLabels: []string{"zone"}, TargetLabel: target}
+		if err := relabel.compile(); err != nil {
+			t.Errorf("target_label %q was rejected: %v", target, err)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// labelNamePattern matches valid Prometheus label names.
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// remoteWriteConfig holds the settings to send metrics to a Prometheus remote_write
+// endpoint, such as Grafana Cloud or Mimir. Remote writing is disabled without a URL.
+type remoteWriteConfig struct {
+	URL            string            `yaml:"url"`
+	Interval       time.Duration     `yaml:"interval"`
+	ExternalLabels map[string]string `yaml:"external_labels"`
+	Headers        map[string]string `yaml:"headers"`
+	Username       string            `yaml:"username"`
+	Password       string            `yaml:"password"`
+	BearerToken    string            `yaml:"bearer_token"`
+
+	// QueueCapacity is the number of collections kept to be sent again while the
+	// endpoint is unavailable. The oldest ones are dropped beyond it.
+	QueueCapacity int `yaml:"queue_capacity"`
+}
+
+func (r *remoteWriteConfig) validate() error {
+	if r.URL == "" {
+		return nil
+	}
+
+	if url, err := url.Parse(r.URL); err != nil || url.Scheme == "" || url.Host == "" {
+		return fmt.Errorf("url %q does not seem to be a valid URL", r.URL)
+	}
+
+	if r.Interval <= 0 {
+		return errors.New("interval must be greater than 0")
+	}
+
+	if r.QueueCapacity < 1 {
+		return errors.New("queue_capacity must be at least 1")
+	}
+
+	if r.BearerToken != "" && (r.Username != "" || r.Password != "") {
+		return errors.New("bearer_token can't be combined with username and password")
+	}
+
+	for name, value := range r.ExternalLabels {
+		if !labelNamePattern.MatchString(name) || name == metricNameLabel {
+			return fmt.Errorf("invalid external label name %q", name)
+		}
+
+		if value == "" {
+			return fmt.Errorf("external label %q must have a value", name)
+		}
+	}
+
+	for name := range r.Headers {
+		if name == "" {
+			return errors.New("header names must not be empty")
+		}
+	}
+
+	return nil
+}
+
+// remoteWriter sends the exporter's metrics to the remote_write endpoint of the
+// current configuration, if any, on its interval. Every collection is queued, and
+// the queue is sent oldest first, so that collections which failed to send with a
+// recoverable error are retried in order on the next interval.
+type remoteWriter struct {
+	exporter *exporter
+	client   *http.Client
+	queue    []*remoteWriteBatch
+	metrics  *senderMetrics
+
+	sentBatches    prometheus.Counter
+	sentSamples    prometheus.Counter
+	failedSends    prometheus.Counter
+	droppedBatches *prometheus.CounterVec
+	queueLength    prometheus.Gauge
+}
+
+// remoteWriteBatch is a single collection, encoded as a snappy-compressed
+// WriteRequest.
+type remoteWriteBatch struct {
+	payload []byte
+	samples int
+}
+
+// remoteWriteError is returned when the endpoint rejects a batch. Only server
+// errors and rate limiting are worth retrying.
+type remoteWriteError struct {
+	status int
+	body   string
+}
+
+func (e *remoteWriteError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %d: %s", e.status, e.body)
+}
+
+func (e *remoteWriteError) recoverable() bool {
+	return e.status >= http.StatusInternalServerError || e.status == http.StatusTooManyRequests
+}
+
+func newRemoteWriter(exporter *exporter) *remoteWriter {
+	w := &remoteWriter{
+		exporter: exporter,
+		client:   &http.Client{},
+		sentBatches: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spacelift_remote_write_sent_batches_total",
+			Help: "The number of collections sent to the remote_write endpoint",
+		}),
+		sentSamples: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spacelift_remote_write_sent_samples_total",
+			Help: "The number of samples sent to the remote_write endpoint",
+		}),
+		failedSends: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spacelift_remote_write_failed_sends_total",
+			Help: "The number of attempts to send a collection to the remote_write endpoint that failed",
+		}),
+		droppedBatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spacelift_remote_write_dropped_batches_total",
+			Help: "The number of collections dropped without being sent to the remote_write endpoint, by reason",
+		}, []string{"reason"}),
+		queueLength: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "spacelift_remote_write_queue_length",
+			Help: "The number of collections waiting to be sent to the remote_write endpoint",
+		}),
+	}
+	w.metrics = newSenderMetrics(exporter, w.sentBatches, w.sentSamples, w.failedSends, w.droppedBatches, w.queueLength)
+
+	return w
+}
+
+// run collects and sends metrics until ctx is cancelled. The queue is emptied while
+// remote writing is disabled.
+func (w *remoteWriter) run(ctx context.Context) {
+	(&intervalSender[remoteWriteConfig]{
+		settings: func(cfg *config) *remoteWriteConfig {
+			if cfg.RemoteWrite.URL == "" {
+				return nil
+			}
+
+			return &cfg.RemoteWrite
+		},
+		interval: func(settings *remoteWriteConfig) time.Duration { return settings.Interval },
+		send:     w.write,
+		disabled: func() { w.queue = nil },
+	}).run(ctx, w.exporter)
+}
+
+func (w *remoteWriter) write(ctx context.Context, settings *remoteWriteConfig) {
+	w.metrics.register()
+
+	families, err := w.exporter.Gather()
+	if err != nil {
+		// Gather still returns whatever it could collect.
+		w.exporter.logger.Warnw("Some metrics could not be gathered for remote_write", zap.Error(err))
+	}
+
+	w.enqueue(encodeWriteRequest(families, settings.ExternalLabels, time.Now()), settings.QueueCapacity)
+	defer func() { w.queueLength.Set(float64(len(w.queue))) }()
+
+	ctx, cancel := context.WithTimeout(ctx, settings.Interval)
+	defer cancel()
+
+	for len(w.queue) > 0 {
+		batch := w.queue[0]
+
+		err := w.send(ctx, settings, batch)
+
+		var writeErr *remoteWriteError
+		switch {
+		case err == nil:
+			w.sentBatches.Inc()
+			w.sentSamples.Add(float64(batch.samples))
+		case errors.As(err, &writeErr) && !writeErr.recoverable():
+			// Sending it again would only fail the same way.
+			w.exporter.logger.Errorw("The remote_write endpoint rejected a collection, dropping it", "url", settings.URL, zap.Error(err))
+			w.droppedBatches.WithLabelValues("rejected").Inc()
+		default:
+			w.exporter.logger.Errorw("Failed to send metrics to the remote_write endpoint, will retry on the next interval", "url", settings.URL, zap.Error(err))
+			w.failedSends.Inc()
+			return
+		}
+
+		w.queue = w.queue[1:]
+	}
+}
+
+func (w *remoteWriter) enqueue(batch *remoteWriteBatch, capacity int) {
+	if overflow := len(w.queue) + 1 - capacity; overflow > 0 {
+		w.queue = w.queue[overflow:]
+		w.droppedBatches.WithLabelValues("queue_full").Add(float64(overflow))
+	}
+
+	w.queue = append(w.queue, batch)
+}
+
+func (w *remoteWriter) send(ctx context.Context, settings *remoteWriteConfig, batch *remoteWriteBatch) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.URL, bytes.NewReader(batch.payload))
+	if err != nil {
+		return err
+	}
+
+	for name, value := range settings.Headers {
+		request.Header.Set(name, value)
+	}
+
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("User-Agent", "spacelift-promex")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	switch {
+	case settings.BearerToken != "":
+		request.Header.Set("Authorization", "Bearer "+settings.BearerToken)
+	case settings.Username != "" || settings.Password != "":
+		request.SetBasicAuth(settings.Username, settings.Password)
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return &remoteWriteError{status: response.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	return nil
+}
+
+// remoteWriteSample is a single sample of a metric family, with the labels that set
+// it apart from the family's other samples.
+type remoteWriteSample struct {
+	suffix string
+	labels []*dto.LabelPair
+	value  float64
+}
+
+// encodeWriteRequest encodes the metric families as a remote_write WriteRequest,
+// which is hand-encoded here to avoid depending on Prometheus itself:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+//
+// Histograms and summaries are flattened into series the same way Prometheus stores
+// them when scraping.
+func encodeWriteRequest(families []*dto.MetricFamily, externalLabels map[string]string, now time.Time) *remoteWriteBatch {
+	var request []byte
+	samples := 0
+
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			timestamp := now.UnixMilli()
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+
+			for _, sample := range metricSamples(family.GetType(), metric) {
+				labels := seriesLabels(family.GetName()+sample.suffix, append(slices.Clone(metric.Label), sample.labels...), externalLabels)
+
+				var series []byte
+				for _, label := range labels {
+					var encoded []byte
+					encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+					encoded = protowire.AppendString(encoded, label.GetName())
+					encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+					encoded = protowire.AppendString(encoded, label.GetValue())
+
+					series = protowire.AppendTag(series, 1, protowire.BytesType)
+					series = protowire.AppendBytes(series, encoded)
+				}
+
+				var encoded []byte
+				encoded = protowire.AppendTag(encoded, 1, protowire.Fixed64Type)
+				encoded = protowire.AppendFixed64(encoded, math.Float64bits(sample.value))
+				encoded = protowire.AppendTag(encoded, 2, protowire.VarintType)
+				encoded = protowire.AppendVarint(encoded, uint64(timestamp))
+
+				series = protowire.AppendTag(series, 2, protowire.BytesType)
+				series = protowire.AppendBytes(series, encoded)
+
+				request = protowire.AppendTag(request, 1, protowire.BytesType)
+				request = protowire.AppendBytes(request, series)
+				samples++
+			}
+		}
+	}
+
+	return &remoteWriteBatch{
+		payload: snappy.Encode(nil, request),
+		samples: samples,
+	}
+}
+
+// metricSamples flattens a metric into its samples.
+func metricSamples(metricType dto.MetricType, metric *dto.Metric) []remoteWriteSample {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return []remoteWriteSample{{value: metric.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []remoteWriteSample{{value: metric.GetGauge().GetValue()}}
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+
+		samples := []remoteWriteSample{
+			{suffix: "_sum", value: summary.GetSampleSum()},
+			{suffix: "_count", value: float64(summary.GetSampleCount())},
+		}
+		for _, quantile := range summary.GetQuantile() {
+			samples = append(samples, remoteWriteSample{
+				labels: []*dto.LabelPair{labelPair("quantile", formatFloat(quantile.GetQuantile()))},
+				value:  quantile.GetValue(),
+			})
+		}
+
+		return samples
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		histogram := metric.GetHistogram()
+
+		samples := []remoteWriteSample{
+			{suffix: "_sum", value: histogram.GetSampleSum()},
+			{suffix: "_count", value: float64(histogram.GetSampleCount())},
+		}
+
+		infinite := false
+		for _, bucket := range histogram.GetBucket() {
+			infinite = infinite || math.IsInf(bucket.GetUpperBound(), 1)
+			samples = append(samples, remoteWriteSample{
+				suffix: "_bucket",
+				labels: []*dto.LabelPair{labelPair("le", formatFloat(bucket.GetUpperBound()))},
+				value:  float64(bucket.GetCumulativeCount()),
+			})
+		}
+
+		if !infinite {
+			samples = append(samples, remoteWriteSample{
+				suffix: "_bucket",
+				labels: []*dto.LabelPair{labelPair("le", "+Inf")},
+				value:  float64(histogram.GetSampleCount()),
+			})
+		}
+
+		return samples
+	default:
+		return []remoteWriteSample{{value: metric.GetUntyped().GetValue()}}
+	}
+}
+
+// seriesLabels returns the labels of a series sorted by name, as remote_write
+// requires, including its name and the external labels it doesn't already have.
+func seriesLabels(name string, labels []*dto.LabelPair, externalLabels map[string]string) []*dto.LabelPair {
+	labels = append(labels, labelPair(metricNameLabel, name))
+
+	for external, value := range externalLabels {
+		if !slices.ContainsFunc(labels, func(label *dto.LabelPair) bool { return label.GetName() == external }) {
+			labels = append(labels, labelPair(external, value))
+		}
+	}
+
+	slices.SortFunc(labels, func(a, b *dto.LabelPair) int {
+		return strings.Compare(a.GetName(), b.GetName())
+	})
+
+	return labels
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// formatFloat formats bucket bounds and quantiles like the Prometheus exposition
+// format does.
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receivedSeries is a time series decoded from a WriteRequest, with its labels in the
+// order they were sent.
+type receivedSeries struct {
+	labels  [][2]string
+	samples []float64
+}
+
+func (s *receivedSeries) String() string {
+	pairs := make([]string, 0, len(s.labels))
+	for _, label := range s.labels {
+		pairs = append(pairs, label[0]+"="+label[1])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// remoteWriteReceiver is a stand-in remote_write endpoint that records every
+// WriteRequest it accepts, and responds with the status it's told to.
+type remoteWriteReceiver struct {
+	t *testing.T
+
+	mutex    sync.Mutex
+	status   int
+	requests [][]*receivedSeries
+}
+
+func (r *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if req.Header.Get("Content-Encoding") != "snappy" || req.Header.Get("Content-Type") != "application/x-protobuf" {
+		r.t.Errorf("unexpected headers %v", req.Header)
+	}
+
+	if r.status/100 != 2 {
+		http.Error(w, http.StatusText(r.status), r.status)
+		return
+	}
+
+	compressed, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Fatalf("could not read request: %v", err)
+	}
+
+	payload, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		r.t.Fatalf("could not decompress request: %v", err)
+	}
+
+	r.requests = append(r.requests, decodeWriteRequest(r.t, payload))
+	w.WriteHeader(r.status)
+}
+
+func (r *remoteWriteReceiver) respondWith(status int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.status = status
+}
+
+func (r *remoteWriteReceiver) received() [][]*receivedSeries {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return slices.Clone(r.requests)
+}
+
+// decodeWriteRequest parses a WriteRequest independently of encodeWriteRequest.
+func decodeWriteRequest(t *testing.T, payload []byte) []*receivedSeries {
+	t.Helper()
+
+	var result []*receivedSeries
+	forEachField(t, payload, func(number protowire.Number, value []byte) {
+		if number != 1 {
+			t.Fatalf("unexpected WriteRequest field %d", number)
+		}
+
+		series := &receivedSeries{}
+		forEachField(t, value, func(number protowire.Number, value []byte) {
+			switch number {
+			case 1:
+				var label [2]string
+				forEachField(t, value, func(number protowire.Number, value []byte) {
+					label[number-1] = string(value)
+				})
+				series.labels = append(series.labels, label)
+			case 2:
+				forEachField(t, value, func(number protowire.Number, value []byte) {
+					if number == 1 {
+						bits, _ := protowire.ConsumeFixed64(value)
+						series.samples = append(series.samples, math.Float64frombits(bits))
+					}
+				})
+			default:
+				t.Fatalf("unexpected TimeSeries field %d", number)
+			}
+		})
+
+		result = append(result, series)
+	})
+
+	return result
+}
+
+// forEachField calls fn with the number and raw value of every field of a message.
+// Length-delimited values are passed without their length.
+func forEachField(t *testing.T, message []byte, fn func(protowire.Number, []byte)) {
+	t.Helper()
+
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		message = message[n:]
+
+		var value []byte
+		switch wireType {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(message)
+		case protowire.Fixed64Type:
+			n = protowire.ConsumeFieldValue(number, wireType, message)
+			value = message[:n]
+		default:
+			n = protowire.ConsumeFieldValue(number, wireType, message)
+		}
+		if n < 0 {
+			t.Fatalf("invalid value of field %d: %v", number, protowire.ParseError(n))
+		}
+		message = message[n:]
+
+		fn(number, value)
+	}
+}
+
+func findSeries(series []*receivedSeries, labels string) *receivedSeries {
+	for _, s := range series {
+		if s.String() == labels {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func newTestRemoteWriter(t *testing.T) (*remoteWriter, *remoteWriteReceiver, *remoteWriteConfig) {
+	t.Helper()
+
+	reg := prometheus.NewRegistry()
+
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total", Help: "Requests"}, []string{"zone", "env"})
+	requests.WithLabelValues("b", "staging").Add(3)
+	reg.MustRegister(requests)
+
+	durations := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "Durations", Buckets: []float64{1, 5}})
+	durations.Observe(0.5)
+	durations.Observe(3)
+	durations.Observe(10)
+	reg.MustRegister(durations)
+
+	e := &exporter{logger: zap.NewNop().Sugar(), registry: prometheus.NewRegistry()}
+	e.current.Store(&pipeline{config: &config{}, gatherer: reg})
+
+	receiver := &remoteWriteReceiver{t: t, status: http.StatusNoContent}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	settings := &remoteWriteConfig{
+		URL:            server.URL,
+		Interval:       time.Minute,
+		ExternalLabels: map[string]string{"cluster": "eu", "env": "production"},
+		QueueCapacity:  5,
+	}
+
+	return newRemoteWriter(e), receiver, settings
+}
+
+func TestRemoteWriteEncoding(t *testing.T) {
+	writer, receiver, settings := newTestRemoteWriter(t)
+
+	writer.write(context.Background(), settings)
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+
+	for _, series := range requests[0] {
+		if !slices.IsSortedFunc(series.labels, func(a, b [2]string) int { return strings.Compare(a[0], b[0]) }) {
+			t.Errorf("labels of %s are not sorted", series)
+		}
+	}
+
+	for labels, want := range map[string]float64{
+		// The external env label doesn't replace the metric's own.
+		"__name__=test_requests_total,cluster=eu,env=staging,zone=b":              3,
+		"__name__=test_duration_seconds_bucket,cluster=eu,env=production,le=1":    1,
+		"__name__=test_duration_seconds_bucket,cluster=eu,env=production,le=5":    2,
+		"__name__=test_duration_seconds_bucket,cluster=eu,env=production,le=+Inf": 3,
+		"__name__=test_duration_seconds_count,cluster=eu,env=production":          3,
+		"__name__=test_duration_seconds_sum,cluster=eu,env=production":            13.5,
+	} {
+		series := findSeries(requests[0], labels)
+		if series == nil {
+			t.Errorf("series %s was not sent", labels)
+			continue
+		}
+
+		if !slices.Equal(series.samples, []float64{want}) {
+			t.Errorf("series %s has samples %v, want [%v]", labels, series.samples, want)
+		}
+	}
+}
+
+func TestRemoteWriteRetries(t *testing.T) {
+	writer, receiver, settings := newTestRemoteWriter(t)
+
+	receiver.respondWith(http.StatusServiceUnavailable)
+	writer.write(context.Background(), settings)
+
+	if len(writer.queue) != 1 {
+		t.Fatalf("got %d batches queued after a server error, want 1", len(writer.queue))
+	}
+
+	receiver.respondWith(http.StatusNoContent)
+	writer.write(context.Background(), settings)
+
+	if len(writer.queue) != 0 {
+		t.Errorf("got %d batches queued after a successful send, want 0", len(writer.queue))
+	}
+
+	// The first request shows no failed sends, while the one sent after it does.
+	requests := receiver.received()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+
+	for i, want := range []float64{0, 1} {
+		series := findSeries(requests[i], "__name__=spacelift_remote_write_failed_sends_total,cluster=eu,env=production")
+		if series == nil || !slices.Equal(series.samples, []float64{want}) {
+			t.Errorf("request %d has failed sends %v, want %v", i, series, want)
+		}
+	}
+}
+
+func TestRemoteWriteDropsRejectedBatches(t *testing.T) {
+	writer, receiver, settings := newTestRemoteWriter(t)
+
+	receiver.respondWith(http.StatusBadRequest)
+	writer.write(context.Background(), settings)
+
+	if len(writer.queue) != 0 {
+		t.Errorf("got %d batches queued after a client error, want 0", len(writer.queue))
+	}
+
+	if dropped := testutil.ToFloat64(writer.droppedBatches.WithLabelValues("rejected")); dropped != 1 {
+		t.Errorf("got %v rejected batches, want 1", dropped)
+	}
+}
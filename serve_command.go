@@ -6,23 +6,33 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/urfave/cli/v3"
 	"go.uber.org/zap"
+	"go.uber.org/zap/exp/zapslog"
 
+	"github.com/spacelift-io/prometheus-exporter/client"
 	"github.com/spacelift-io/prometheus-exporter/client/session"
 	"github.com/spacelift-io/prometheus-exporter/logging"
 )
 
 var (
+	configFile     string
+	flagConfigFile = &cli.StringFlag{
+		Name: "config-file",
+		Usage: "Path to a YAML configuration file. Its settings take precedence over the flags, and it's reloaded " +
+			"on SIGHUP, on a POST to /-/reload if --web.enable-lifecycle is set, and whenever it changes.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_CONFIG_FILE"),
+		Destination: &configFile,
+	}
+
 	listenAddress     string
 	flagListenAddress = &cli.StringFlag{
 		Name:        "listen-address",
@@ -33,13 +43,29 @@ var (
 		Destination: &listenAddress,
 	}
 
+	webConfigFile     string
+	flagWebConfigFile = &cli.StringFlag{
+		Name: "web.config.file",
+		Usage: "Path to a Prometheus web configuration file enabling TLS and authentication for the HTTP listener. " +
+			"See https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md for the format.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_WEB_CONFIG_FILE"),
+		Destination: &webConfigFile,
+	}
+
+	webEnableLifecycle     bool
+	flagWebEnableLifecycle = &cli.BoolFlag{
+		Name:        "web.enable-lifecycle",
+		Usage:       "Enables reloading the configuration with a POST to /-/reload",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_WEB_ENABLE_LIFECYCLE"),
+		Destination: &webEnableLifecycle,
+	}
+
 	apiEndpoint     string
 	flagAPIEndpoint = &cli.StringFlag{
 		Name:        "api-endpoint",
 		Aliases:     []string{"e"},
 		Usage:       "Your spacelift API endpoint (e.g. https://myaccount.app.spacelift.io)",
 		Sources:     cli.EnvVars("SPACELIFT_PROMEX_API_ENDPOINT"),
-		Required:    true,
 		Destination: &apiEndpoint,
 	}
 
@@ -57,7 +83,6 @@ var (
 		Aliases:     []string{"k"},
 		Usage:       "Your spacelift API key ID",
 		Sources:     cli.EnvVars("SPACELIFT_PROMEX_API_KEY_ID"),
-		Required:    true,
 		Destination: &apiKeyID,
 	}
 
@@ -98,22 +123,315 @@ var (
 		Value:       time.Second * 5,
 		Destination: &scrapeTimeout,
 	}
+
+	pollInterval     time.Duration
+	flagPollInterval = &cli.DurationFlag{
+		Name: "poll-interval",
+		Usage: "How often to refresh metrics from the Spacelift API in the background. When set, scrapes are " +
+			"served from the latest cached snapshot instead of querying the API on every scrape. Disabled by default.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_POLL_INTERVAL"),
+		Destination: &pollInterval,
+	}
+
+	retryMaxAttempts     int
+	flagRetryMaxAttempts = &cli.IntFlag{
+		Name:        "retry-max-attempts",
+		Usage:       "The maximum number of attempts for a request to the Spacelift API that fails for a transient reason, including the first one",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_RETRY_MAX_ATTEMPTS"),
+		Value:       client.DefaultRetryPolicy().MaxAttempts,
+		Destination: &retryMaxAttempts,
+	}
+
+	retryInitialBackoff     time.Duration
+	flagRetryInitialBackoff = &cli.DurationFlag{
+		Name:        "retry-initial-backoff",
+		Usage:       "The delay before the first retry of a failed request to the Spacelift API. It doubles with every subsequent retry",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_RETRY_INITIAL_BACKOFF"),
+		Value:       client.DefaultRetryPolicy().InitialBackoff,
+		Destination: &retryInitialBackoff,
+	}
+
+	retryMaxBackoff     time.Duration
+	flagRetryMaxBackoff = &cli.DurationFlag{
+		Name:        "retry-max-backoff",
+		Usage:       "The maximum delay between retries of a failed request to the Spacelift API, unless the API asks for a longer one with Retry-After",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_RETRY_MAX_BACKOFF"),
+		Value:       client.DefaultRetryPolicy().MaxBackoff,
+		Destination: &retryMaxBackoff,
+	}
+
+	retryJitter     float64
+	flagRetryJitter = &cli.FloatFlag{
+		Name:        "retry-jitter",
+		Usage:       "The fraction by which every delay between retries is randomised, between 0 and 1",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_RETRY_JITTER"),
+		Value:       client.DefaultRetryPolicy().Jitter,
+		Destination: &retryJitter,
+	}
+
+	collectors     []string
+	flagCollectors = &cli.StringSliceFlag{
+		Name: "collectors",
+		Usage: fmt.Sprintf("The collectors to enable. Prefix a collector with - to disable it instead, in which case the "+
+			"remaining default collectors stay enabled. Available collectors: %s.", strings.Join(availableSubCollectors(), ", ")),
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_COLLECTORS"),
+		Value:       defaultSubCollectors(),
+		Destination: &collectors,
+	}
+
+	webhookSecret     string
+	flagWebhookSecret = &cli.StringFlag{
+		Name: "webhook-secret",
+		Usage: "The secret used to verify the signature of Spacelift run state change webhooks. When set, " +
+			"webhooks are accepted on the /webhooks endpoint and turned into run counters and duration histograms.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_WEBHOOK_SECRET"),
+		Destination: &webhookSecret,
+	}
+
+	pushGatewayURL     string
+	flagPushGatewayURL = &cli.StringFlag{
+		Name: "push-gateway-url",
+		Usage: "The URL of a Prometheus Pushgateway to push metrics to on an interval, for environments where " +
+			"the exporter can't be scraped. The metrics are still served on /metrics.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_PUSH_GATEWAY_URL"),
+		Destination: &pushGatewayURL,
+	}
+
+	pushGatewayJob     string
+	flagPushGatewayJob = &cli.StringFlag{
+		Name:        "push-gateway-job",
+		Usage:       "The job label to push metrics to the Pushgateway under",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_PUSH_GATEWAY_JOB"),
+		Value:       "spacelift",
+		Destination: &pushGatewayJob,
+	}
+
+	pushGatewayGrouping     []string
+	flagPushGatewayGrouping = &cli.StringSliceFlag{
+		Name:        "push-gateway-grouping",
+		Usage:       "Additional labels of the Pushgateway grouping key, as label=value pairs",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_PUSH_GATEWAY_GROUPING"),
+		Destination: &pushGatewayGrouping,
+	}
+
+	pushGatewayInterval     time.Duration
+	flagPushGatewayInterval = &cli.DurationFlag{
+		Name:        "push-gateway-interval",
+		Usage:       "How often to push metrics to the Pushgateway",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_PUSH_GATEWAY_INTERVAL"),
+		Value:       time.Minute,
+		Destination: &pushGatewayInterval,
+	}
+
+	pushGatewayUsername     string
+	flagPushGatewayUsername = &cli.StringFlag{
+		Name:        "push-gateway-username",
+		Usage:       "The username to authenticate to the Pushgateway with basic auth",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_PUSH_GATEWAY_USERNAME"),
+		Destination: &pushGatewayUsername,
+	}
+
+	pushGatewayPassword     string
+	flagPushGatewayPassword = &cli.StringFlag{
+		Name:        "push-gateway-password",
+		Usage:       "The password to authenticate to the Pushgateway with basic auth",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_PUSH_GATEWAY_PASSWORD"),
+		Destination: &pushGatewayPassword,
+	}
+
+	remoteWriteURL     string
+	flagRemoteWriteURL = &cli.StringFlag{
+		Name: "remote-write-url",
+		Usage: "The URL of a Prometheus remote_write endpoint to send metrics to on an interval, such as Grafana Cloud " +
+			"or Mimir. The metrics are still served on /metrics.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_REMOTE_WRITE_URL"),
+		Destination: &remoteWriteURL,
+	}
+
+	remoteWriteInterval     time.Duration
+	flagRemoteWriteInterval = &cli.DurationFlag{
+		Name:        "remote-write-interval",
+		Usage:       "How often to collect metrics and send them to the remote_write endpoint",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_REMOTE_WRITE_INTERVAL"),
+		Value:       time.Minute,
+		Destination: &remoteWriteInterval,
+	}
+
+	remoteWriteExternalLabels     []string
+	flagRemoteWriteExternalLabels = &cli.StringSliceFlag{
+		Name:        "remote-write-external-label",
+		Usage:       "Labels to add to every series sent to the remote_write endpoint, as label=value pairs",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_REMOTE_WRITE_EXTERNAL_LABELS"),
+		Destination: &remoteWriteExternalLabels,
+	}
+
+	remoteWriteHeaders     []string
+	flagRemoteWriteHeaders = &cli.StringSliceFlag{
+		Name:        "remote-write-header",
+		Usage:       "HTTP headers to send to the remote_write endpoint, such as X-Scope-OrgID, as name=value pairs",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_REMOTE_WRITE_HEADERS"),
+		Destination: &remoteWriteHeaders,
+	}
+
+	remoteWriteUsername     string
+	flagRemoteWriteUsername = &cli.StringFlag{
+		Name:        "remote-write-username",
+		Usage:       "The username to authenticate to the remote_write endpoint with basic auth",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_REMOTE_WRITE_USERNAME"),
+		Destination: &remoteWriteUsername,
+	}
+
+	remoteWritePassword     string
+	flagRemoteWritePassword = &cli.StringFlag{
+		Name:        "remote-write-password",
+		Usage:       "The password to authenticate to the remote_write endpoint with basic auth",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_REMOTE_WRITE_PASSWORD"),
+		Destination: &remoteWritePassword,
+	}
+
+	remoteWriteBearerToken     string
+	flagRemoteWriteBearerToken = &cli.StringFlag{
+		Name:        "remote-write-bearer-token",
+		Usage:       "The bearer token to authenticate to the remote_write endpoint with. Can't be combined with basic auth.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_REMOTE_WRITE_BEARER_TOKEN"),
+		Destination: &remoteWriteBearerToken,
+	}
+
+	remoteWriteQueueCapacity     int
+	flagRemoteWriteQueueCapacity = &cli.IntFlag{
+		Name: "remote-write-queue-capacity",
+		Usage: "The number of collections kept to be sent again while the remote_write endpoint is unavailable. " +
+			"The oldest ones are dropped beyond it.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_REMOTE_WRITE_QUEUE_CAPACITY"),
+		Value:       10,
+		Destination: &remoteWriteQueueCapacity,
+	}
+
+	otlpEndpoint     string
+	flagOTLPEndpoint = &cli.StringFlag{
+		Name: "otlp-endpoint",
+		Usage: "The URL of an OpenTelemetry collector to export metrics to over OTLP on an interval. The metrics " +
+			"are still served on /metrics.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_OTLP_ENDPOINT"),
+		Destination: &otlpEndpoint,
+	}
+
+	otlpProtocol     string
+	flagOTLPProtocol = &cli.StringFlag{
+		Name:        "otlp-protocol",
+		Usage:       "The OTLP protocol to use, either grpc or http/protobuf",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_OTLP_PROTOCOL"),
+		Value:       otlpProtocolGRPC,
+		Destination: &otlpProtocol,
+	}
+
+	otlpInterval     time.Duration
+	flagOTLPInterval = &cli.DurationFlag{
+		Name:        "otlp-interval",
+		Usage:       "How often to export metrics over OTLP",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_OTLP_INTERVAL"),
+		Value:       time.Minute,
+		Destination: &otlpInterval,
+	}
+
+	otlpHeaders     []string
+	flagOTLPHeaders = &cli.StringSliceFlag{
+		Name:        "otlp-header",
+		Usage:       "Headers to send with every OTLP export, as name=value pairs",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_OTLP_HEADERS"),
+		Destination: &otlpHeaders,
+	}
+
+	otlpResourceAttributes     []string
+	flagOTLPResourceAttributes = &cli.StringSliceFlag{
+		Name:        "otlp-resource-attribute",
+		Usage:       "Additional resource attributes of the exported metrics, as name=value pairs",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_OTLP_RESOURCE_ATTRIBUTES"),
+		Destination: &otlpResourceAttributes,
+	}
+
+	statsdAddress     string
+	flagStatsdAddress = &cli.StringFlag{
+		Name: "statsd-address",
+		Usage: "The address of a StatsD server supporting DogStatsD tags, such as the Datadog agent, to send metrics to " +
+			"on an interval. Either host:port or udp://host:port for UDP, or unix:///path/to/socket for a Unix datagram socket.",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_STATSD_ADDRESS"),
+		Destination: &statsdAddress,
+	}
+
+	statsdInterval     time.Duration
+	flagStatsdInterval = &cli.DurationFlag{
+		Name:        "statsd-interval",
+		Usage:       "How often to collect metrics and send them to StatsD",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_STATSD_INTERVAL"),
+		Value:       time.Minute,
+		Destination: &statsdInterval,
+	}
+
+	statsdPrefix     string
+	flagStatsdPrefix = &cli.StringFlag{
+		Name:        "statsd-prefix",
+		Usage:       "A prefix to add to the name of every metric sent to StatsD",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_STATSD_PREFIX"),
+		Destination: &statsdPrefix,
+	}
+
+	statsdTags     []string
+	flagStatsdTags = &cli.StringSliceFlag{
+		Name:        "statsd-tag",
+		Usage:       "Tags to add to every metric sent to StatsD, as name=value pairs",
+		Sources:     cli.EnvVars("SPACELIFT_PROMEX_STATSD_TAGS"),
+		Destination: &statsdTags,
+	}
 )
 
 var serveCommand *cli.Command = &cli.Command{
 	Name:  "serve",
 	Usage: "Starts the Prometheus exporter",
 	Flags: []cli.Flag{
+		flagConfigFile,
 		flagListenAddress,
+		flagWebConfigFile,
+		flagWebEnableLifecycle,
 		flagAPIEndpoint,
 		flagCACertPath,
 		flagAPIKeyID,
 		flagIsDevelopment,
 		flagScrapeTimeout,
+		flagPollInterval,
+		flagCollectors,
+		flagRetryMaxAttempts,
+		flagRetryInitialBackoff,
+		flagRetryMaxBackoff,
+		flagRetryJitter,
+		flagWebhookSecret,
+		flagPushGatewayURL,
+		flagPushGatewayJob,
+		flagPushGatewayGrouping,
+		flagPushGatewayInterval,
+		flagPushGatewayUsername,
+		flagPushGatewayPassword,
+		flagRemoteWriteURL,
+		flagRemoteWriteInterval,
+		flagRemoteWriteExternalLabels,
+		flagRemoteWriteHeaders,
+		flagRemoteWriteUsername,
+		flagRemoteWritePassword,
+		flagRemoteWriteBearerToken,
+		flagRemoteWriteQueueCapacity,
+		flagOTLPEndpoint,
+		flagOTLPProtocol,
+		flagOTLPInterval,
+		flagOTLPHeaders,
+		flagOTLPResourceAttributes,
+		flagStatsdAddress,
+		flagStatsdInterval,
+		flagStatsdPrefix,
+		flagStatsdTags,
 	},
 	MutuallyExclusiveFlags: []cli.MutuallyExclusiveFlags{
 		{
-			Required: true,
+			// Not required, as the secret can also come from the configuration file.
 			Flags: [][]cli.Flag{
 				{flagAPIKeySecret},
 				{flagAPIKeySecretFile},
@@ -121,40 +439,28 @@ var serveCommand *cli.Command = &cli.Command{
 		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
-		ctx = logging.Init(ctx, isDevelopment)
-		logger := logging.FromContext(ctx).Sugar()
-
-		if scrapeTimeout <= 0 {
-			return cli.Exit("scrape-timeout must be greater than 0", ExitCodeStartupError)
-		}
-
-		if url, err := url.Parse(apiEndpoint); err != nil || url.Scheme == "" || url.Host == "" {
-			return cli.Exit(fmt.Sprintf("api-endpoint %q does not seem to be a valid URL", apiEndpoint), ExitCodeStartupError)
-		}
-
-		secretProvider, err := buildSecretProvider(apiKeySecret, apiKeySecretFile)
+		// The configuration decides how we log, so we can only report its errors once
+		// it's been loaded.
+		cfg, err := loadConfig(configFile)
 		if err != nil {
 			return cli.Exit(err.Error(), ExitCodeStartupError)
 		}
 
-		httpClient, err := newHTTPClient(caCertPath)
-		if err != nil {
-			return cli.Exit(fmt.Sprintf("could not configure HTTP client: %v", err), ExitCodeStartupError)
-		}
+		ctx = logging.Init(ctx, cfg.IsDevelopment)
+		logger := logging.FromContext(ctx).Sugar()
 
 		logger.Info("Prepping exporter for lift-off")
 
-		session, err := func() (session.Session, error) {
-			sessionCtx, cancel := context.WithTimeout(ctx, time.Second*5)
-			defer cancel()
-			return session.NewWithSecretProvider(sessionCtx, httpClient, apiEndpoint, apiKeyID, secretProvider)
-		}()
+		exporter, err := newExporter(ctx, configFile, cfg)
 		if err != nil {
-			logger.Fatalw("failed to create Spacelift API session", zap.Error(err))
-			return cli.Exit("could not create session from Spacelift API key", ExitCodeStartupError)
+			logger.Errorw("failed to start exporter", zap.Error(err))
+			return cli.Exit(err.Error(), ExitCodeStartupError)
 		}
 
-		logger.Info("Successfully created Spacelift API session")
+		go exporter.watch(ctx)
+		go newPusher(exporter).run(ctx)
+		go newRemoteWriter(exporter).run(ctx)
+		go newStatsdSender(exporter).run(ctx)
 
 		http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(`
@@ -168,36 +474,32 @@ var serveCommand *cli.Command = &cli.Command{
 			</html>`))
 		}))
 
-		// Create a new registry.
-		reg := prometheus.NewRegistry()
+		http.Handle("/webhooks", exporter.webhooks)
 
-		collector, err := newSpaceliftCollector(ctx, httpClient, session, scrapeTimeout)
-		if err != nil {
-			return cli.Exit(fmt.Sprintf("could not create Spacelift collector: %v", err), ExitCodeStartupError)
-		}
-		reg.MustRegister(collector)
-
-		// Expose the registered metrics via HTTP.
-		http.Handle("/metrics", promhttp.HandlerFor(
-			reg,
-			promhttp.HandlerOpts{
-				// Opt into OpenMetrics to support exemplars.
-				EnableOpenMetrics: true,
-			},
-		))
+		// Expose the current configuration's metrics via HTTP.
+		http.Handle("/metrics", exporter.metricsHandler())
+		http.Handle("/probe", exporter.probeHandler())
+		http.Handle("/-/reload", exporter.reloadHandler())
 
 		http.Handle("/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("Countdown complete - ready to serve metrics!"))
 		}))
 
-		listenAddress := cmd.String(flagListenAddress.Name)
+		logger.Info("Ready for launch! Listening on ", cfg.ListenAddress)
 
-		logger.Info("Ready for launch! Listening on ", listenAddress)
+		server := http.Server{ReadHeaderTimeout: time.Second * 5}
 
-		server := http.Server{Addr: listenAddress, ReadHeaderTimeout: time.Second * 5}
+		// The web configuration is read again on every request, so TLS certificates and
+		// users can be changed without a restart.
+		webConfig := &web.FlagConfig{
+			WebListenAddresses: &[]string{cfg.ListenAddress},
+			WebSystemdSocket:   new(false),
+			WebConfigFile:      &cfg.WebConfigFile,
+		}
+		webLogger := slog.New(zapslog.NewHandler(logger.Desugar().Core()))
 
 		go func() {
-			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			if err := web.ListenAndServe(&server, webConfig, webLogger); err != nil && !errors.Is(err, http.ErrServerClosed) {
 				logger.Fatalw("Error running HTTP server", zap.Error(err))
 			}
 		}()
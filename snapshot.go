@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// snapshotCache holds the results of the most recent background poll of every
+// sub-collector. A sub-collector whose latest poll failed keeps the metrics of its last
+// successful one.
+type snapshotCache struct {
+	mutex          sync.RWMutex
+	results        map[string]*collectorResult
+	scrapeDuration time.Duration
+}
+
+func (s *snapshotCache) store(results map[string]*collectorResult, scrapeDuration time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Scrapes may still be reading the previous map, so we build a new one rather than
+	// updating it in place.
+	merged := make(map[string]*collectorResult, len(results))
+	for name, result := range results {
+		if previous, ok := s.results[name]; ok && !result.received() {
+			kept := *result
+			kept.cached = previous.served()
+			kept.takenAt = previous.takenAt
+			result = &kept
+		}
+
+		merged[name] = result
+	}
+
+	s.results = merged
+	s.scrapeDuration = scrapeDuration
+}
+
+func (s *snapshotCache) load() (map[string]*collectorResult, time.Duration) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.results, s.scrapeDuration
+}
+
+// poll refreshes the snapshot cache every poll interval until ctx is cancelled, so that
+// scrapes keep returning the last known values with the snapshot age metric showing how
+// stale they are.
+func (c *spaceliftCollector) poll(ctx context.Context) {
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		c.snapshots.store(c.scrape(c.collectorNames))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
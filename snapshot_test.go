@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+func TestSnapshotKeepsMetricsOfFailedPolls(t *testing.T) {
+	desc := prometheus.NewDesc("test_metric", "Test metric", nil, nil)
+	metrics := []prometheus.Metric{prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1)}
+	takenAt := time.Now().Add(-time.Minute)
+
+	var cache snapshotCache
+	cache.store(map[string]*collectorResult{"stacks": {metrics: metrics, takenAt: takenAt}}, time.Second)
+
+	// Every field failed, so the poll returned field errors without any metrics.
+	failed := &collectorResult{
+		err:         errors.New("partial response"),
+		fieldErrors: []client.FieldError{{Path: "stacks", Message: "boom"}},
+	}
+	cache.store(map[string]*collectorResult{"stacks": failed}, time.Second)
+
+	for poll := range 2 {
+		results, _ := cache.load()
+		result := results["stacks"]
+
+		if result.received() {
+			t.Errorf("poll %d: failed poll is reported as received", poll)
+		}
+
+		if !slices.Equal(result.served(), metrics) {
+			t.Errorf("poll %d: got metrics %v, want those of the last successful poll", poll, result.served())
+		}
+
+		if !result.takenAt.Equal(takenAt) {
+			t.Errorf("poll %d: got snapshot time %v, want %v", poll, result.takenAt, takenAt)
+		}
+
+		// A second failed poll keeps serving the same metrics.
+		cache.store(map[string]*collectorResult{"stacks": {err: errors.New("timeout")}}, time.Second)
+	}
+
+	if len(failed.metrics) != 0 || failed.takenAt != (time.Time{}) {
+		t.Error("storing a failed poll modified its result")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+const (
+	// statsdMaxUDPPacketSize keeps datagrams below the usual MTU, as recommended for
+	// DogStatsD over UDP.
+	statsdMaxUDPPacketSize = 1432
+
+	// statsdMaxUnixPacketSize is the default buffer size of the Datadog agent's Unix
+	// socket.
+	statsdMaxUnixPacketSize = 8192
+)
+
+// statsdTagReplacer removes the characters with a meaning in the DogStatsD protocol
+// from tags.
+var statsdTagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+// statsdConfig holds the settings to send metrics to a StatsD server understanding
+// DogStatsD tags, such as the Datadog agent. Sending is disabled without an address.
+type statsdConfig struct {
+	// Address is either a host:port or udp:// URL, or a unix:// URL pointing to the
+	// agent's datagram socket.
+	Address  string            `yaml:"address"`
+	Interval time.Duration     `yaml:"interval"`
+	Prefix   string            `yaml:"prefix"`
+	Tags     map[string]string `yaml:"tags"`
+}
+
+func (s *statsdConfig) validate() error {
+	if s.Address == "" {
+		return nil
+	}
+
+	if _, _, err := s.network(); err != nil {
+		return err
+	}
+
+	if s.Interval <= 0 {
+		return errors.New("interval must be greater than 0")
+	}
+
+	return nil
+}
+
+// network returns the network and address to dial.
+func (s *statsdConfig) network() (string, string, error) {
+	if !strings.Contains(s.Address, "://") {
+		return "udp", s.Address, nil
+	}
+
+	address, err := url.Parse(s.Address)
+	if err != nil {
+		return "", "", fmt.Errorf("address %q does not seem to be a valid URL", s.Address)
+	}
+
+	switch address.Scheme {
+	case "udp":
+		if address.Host == "" {
+			return "", "", fmt.Errorf("address %q has no host", s.Address)
+		}
+
+		return "udp", address.Host, nil
+	case "unix":
+		if address.Path == "" {
+			return "", "", fmt.Errorf("address %q has no path", s.Address)
+		}
+
+		return "unixgram", address.Path, nil
+	default:
+		return "", "", fmt.Errorf("unsupported scheme %q in address %q, must be udp or unix", address.Scheme, s.Address)
+	}
+}
+
+// statsdSender sends the exporter's metrics as DogStatsD gauges to the address of the
+// current configuration, if any, on its interval. Every label becomes a tag.
+type statsdSender struct {
+	exporter *exporter
+	metrics  *senderMetrics
+
+	sentSamples prometheus.Counter
+	failedSends prometheus.Counter
+}
+
+func newStatsdSender(exporter *exporter) *statsdSender {
+	sender := &statsdSender{
+		exporter: exporter,
+		sentSamples: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spacelift_statsd_sent_samples_total",
+			Help: "The number of samples sent to StatsD",
+		}),
+		failedSends: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "spacelift_statsd_failed_sends_total",
+			Help: "The number of collections that failed to send to StatsD",
+		}),
+	}
+	sender.metrics = newSenderMetrics(exporter, sender.sentSamples, sender.failedSends)
+
+	return sender
+}
+
+// run collects and sends metrics until ctx is cancelled.
+func (s *statsdSender) run(ctx context.Context) {
+	(&intervalSender[statsdConfig]{
+		settings: func(cfg *config) *statsdConfig {
+			if cfg.StatsD.Address == "" {
+				return nil
+			}
+
+			return &cfg.StatsD
+		},
+		interval: func(settings *statsdConfig) time.Duration { return settings.Interval },
+		send:     s.send,
+	}).run(ctx, s.exporter)
+}
+
+func (s *statsdSender) send(ctx context.Context, settings *statsdConfig) {
+	s.metrics.register()
+
+	families, err := s.exporter.Gather()
+	if err != nil {
+		// Gather still returns whatever it could collect.
+		s.exporter.logger.Warnw("Some metrics could not be gathered for StatsD", zap.Error(err))
+	}
+
+	network, address, _ := settings.network()
+
+	maxPacketSize := statsdMaxUDPPacketSize
+	if network == "unixgram" {
+		maxPacketSize = statsdMaxUnixPacketSize
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		s.exporter.logger.Errorw("Failed to connect to StatsD", "address", settings.Address, zap.Error(err))
+		s.failedSends.Inc()
+
+		return
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	sent := 0
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+
+		defer packet.Reset()
+		_, err := conn.Write(packet.Bytes())
+
+		return err
+	}
+
+	for _, line := range statsdLines(families, settings) {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			if err := flush(); err != nil {
+				s.exporter.logger.Errorw("Failed to send metrics to StatsD", "address", settings.Address, zap.Error(err))
+				s.failedSends.Inc()
+
+				return
+			}
+		}
+
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+		sent++
+	}
+
+	if err := flush(); err != nil {
+		s.exporter.logger.Errorw("Failed to send metrics to StatsD", "address", settings.Address, zap.Error(err))
+		s.failedSends.Inc()
+
+		return
+	}
+
+	s.sentSamples.Add(float64(sent))
+}
+
+// statsdLines formats every sample of the metric families as a DogStatsD gauge.
+// Counters are sent as gauges of their running total, and histograms and summaries
+// are flattened like remote_write does.
+func statsdLines(families []*dto.MetricFamily, settings *statsdConfig) []string {
+	var lines []string
+
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			for _, sample := range metricSamples(family.GetType(), metric) {
+				if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+					// StatsD has no way to represent these.
+					continue
+				}
+
+				var tags []string
+				for _, label := range slices.Concat(metric.Label, sample.labels) {
+					if label.GetValue() != "" {
+						tags = append(tags, statsdTag(label.GetName(), label.GetValue()))
+					}
+				}
+
+				for name, value := range settings.Tags {
+					tags = append(tags, statsdTag(name, value))
+				}
+
+				line := settings.Prefix + family.GetName() + sample.suffix + ":" + strconv.FormatFloat(sample.value, 'g', -1, 64) + "|g"
+				if len(tags) > 0 {
+					line += "|#" + strings.Join(tags, ",")
+				}
+
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	return lines
+}
+
+func statsdTag(name, value string) string {
+	return statsdTagReplacer.Replace(name) + ":" + statsdTagReplacer.Replace(value)
+}
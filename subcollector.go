@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spacelift-io/prometheus-exporter/client"
+)
+
+// subCollector exposes a group of related metrics backed by its own GraphQL query, so
+// that a failure in one group doesn't take down the others.
+type subCollector interface {
+	// Describe sends the descriptors of all the metrics the sub-collector can produce.
+	Describe(descriptorChannel chan<- *prometheus.Desc)
+
+	// Collect queries the Spacelift API and returns the resulting metrics. If the API
+	// returned partial data, the metrics derived from it are returned along with the
+	// *client.PartialError describing the fields that failed.
+	Collect(ctx context.Context, client client.Client) ([]prometheus.Metric, error)
+}
+
+// configurableSubCollector is implemented by sub-collectors that depend on settings
+// other than their query. They're configured right after being created.
+type configurableSubCollector interface {
+	configure(options collectorOptions)
+}
+
+type subCollectorRegistration struct {
+	enabledByDefault bool
+	factory          func() subCollector
+}
+
+// subCollectors lists every sub-collector that can be selected with the --collectors
+// flag, by name.
+var subCollectors = map[string]subCollectorRegistration{
+	"account_metrics":    {enabledByDefault: true, factory: newAccountMetricsCollector},
+	"active_runs":        {enabledByDefault: false, factory: newActiveRunsCollector},
+	"drift_detection":    {enabledByDefault: false, factory: newDriftDetectionCollector},
+	"modules":            {enabledByDefault: false, factory: newModulesCollector},
+	"policies":           {enabledByDefault: false, factory: newPoliciesCollector},
+	"public_worker_pool": {enabledByDefault: true, factory: newPublicWorkerPoolCollector},
+	"run_history":        {enabledByDefault: false, factory: newRunHistoryCollector},
+	"stacks":             {enabledByDefault: true, factory: newStacksCollector},
+	"spaces":             {enabledByDefault: true, factory: newSpacesCollector},
+	"usage":              {enabledByDefault: true, factory: newUsageCollector},
+	"worker_pools":       {enabledByDefault: true, factory: newWorkerPoolsCollector},
+}
+
+// availableSubCollectors returns the names of all registered sub-collectors, sorted.
+func availableSubCollectors() []string {
+	names := make([]string, 0, len(subCollectors))
+	for name := range subCollectors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// defaultSubCollectors returns the names of the sub-collectors enabled by default, sorted.
+func defaultSubCollectors() []string {
+	var names []string
+	for _, name := range availableSubCollectors() {
+		if subCollectors[name].enabledByDefault {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// resolveSubCollectors turns a --collectors selection into a sorted list of sub-collector
+// names. Plain names enable a sub-collector and names prefixed with "-" disable it. If
+// the selection only disables sub-collectors, they are removed from the default set.
+func resolveSubCollectors(selection []string) ([]string, error) {
+	enabled := make(map[string]bool)
+
+	// Entries split from a comma-separated list keep the spaces after the commas.
+	entries := make([]string, 0, len(selection))
+	onlyDisables := true
+	for _, entry := range selection {
+		entry = strings.TrimSpace(entry)
+		entries = append(entries, entry)
+
+		if !strings.HasPrefix(entry, "-") {
+			onlyDisables = false
+		}
+	}
+
+	if onlyDisables {
+		for _, name := range defaultSubCollectors() {
+			enabled[name] = true
+		}
+	}
+
+	for _, entry := range entries {
+		name, disable := strings.CutPrefix(entry, "-")
+		if _, ok := subCollectors[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q, available collectors are: %s", name, strings.Join(availableSubCollectors(), ", "))
+		}
+
+		enabled[name] = !disable
+	}
+
+	var names []string
+	for name, on := range enabled {
+		if on {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("at least one collector must be enabled")
+	}
+
+	return names, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/spacelift-io/prometheus-exporter/client/structs"
+	"github.com/spacelift-io/prometheus-exporter/logging"
+)
+
+const (
+	// webhookSignatureHeader is the header Spacelift uses to send the HMAC-SHA256
+	// signature of the webhook payload, in the form "sha256=<hex digest>".
+	webhookSignatureHeader = "X-Signature-256"
+
+	// webhookMaxBodyBytes limits the size of webhook payloads we're willing to read.
+	webhookMaxBodyBytes = 1 << 20
+
+	// webhookRunTTL is how long we remember a run that hasn't reached a terminal state.
+	// This stops runs whose terminal webhook never arrives from leaking memory.
+	webhookRunTTL = 24 * time.Hour
+
+	// webhookFinishedRunTTL is how long we remember runs that reached a terminal state,
+	// so that events delivered after the terminal one don't track the run again.
+	webhookFinishedRunTTL = time.Hour
+
+	// webhookEvictionInterval is how often we forget the runs that outlived their TTL.
+	webhookEvictionInterval = time.Minute
+)
+
+// runStateChangedEvent is the payload Spacelift sends to webhooks when a run changes
+// state.
+type runStateChangedEvent struct {
+	Account      string           `json:"account"`
+	State        structs.RunState `json:"state"`
+	StateVersion int              `json:"stateVersion"`
+	Timestamp    int              `json:"timestamp"`
+	Note         *string          `json:"note"`
+	Run          struct {
+		ID          string          `json:"id"`
+		CreatedAt   int             `json:"createdAt"`
+		TriggeredBy *string         `json:"triggeredBy"`
+		Type        structs.RunType `json:"type"`
+	} `json:"run"`
+	Stack struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"stack"`
+}
+
+func (e *runStateChangedEvent) transition() structs.RunStateTransition {
+	return structs.RunStateTransition{
+		Note:      e.Note,
+		State:     e.State,
+		Terminal:  e.State.IsTerminal(),
+		Timestamp: e.Timestamp,
+		Username:  e.Run.TriggeredBy,
+	}
+}
+
+// trackedRun holds what we know about a run in flight between webhook deliveries.
+type trackedRun struct {
+	createdAt time.Time
+	startedAt time.Time
+	seenAt    time.Time
+}
+
+// webhookReceiver accepts Spacelift run state change webhooks and turns them into
+// run counters and queue and execution time histograms.
+type webhookReceiver struct {
+	logger *zap.SugaredLogger
+
+	// secret can be replaced by a configuration reload while webhooks are coming in.
+	secret atomic.Pointer[[]byte]
+
+	runsMutex sync.Mutex
+	runs      map[string]*trackedRun
+
+	// finishedRuns holds when every recently finished run reached its terminal state.
+	finishedRuns map[string]time.Time
+
+	runsFinished      *prometheus.CounterVec
+	runQueueTime      *prometheus.HistogramVec
+	runExecutionTime  *prometheus.HistogramVec
+	rejectedWebhooks  *prometheus.CounterVec
+	lastEventReceived prometheus.Gauge
+}
+
+func newWebhookReceiver(ctx context.Context, secret string) *webhookReceiver {
+	receiver := &webhookReceiver{
+		logger:       logging.FromContext(ctx).Sugar(),
+		runs:         make(map[string]*trackedRun),
+		finishedRuns: make(map[string]time.Time),
+		runsFinished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spacelift_webhook_runs_total",
+			Help: "The number of runs that have reached a terminal state, as reported by webhooks",
+		}, []string{"stack_id", "stack_name", "run_type", "state"}),
+		runQueueTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "spacelift_webhook_run_queue_duration_seconds",
+			Help:    "The time runs spent waiting for a worker before starting, as reported by webhooks",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+		}, []string{"stack_id", "stack_name", "run_type"}),
+		runExecutionTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "spacelift_webhook_run_execution_duration_seconds",
+			Help:    "The time runs took from starting to reaching a terminal state, as reported by webhooks",
+			Buckets: prometheus.ExponentialBuckets(10, 2, 12),
+		}, []string{"stack_id", "stack_name", "run_type", "state"}),
+		rejectedWebhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "spacelift_webhook_rejected_total",
+			Help: "The number of webhook requests that were rejected, by reason",
+		}, []string{"reason"}),
+		lastEventReceived: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "spacelift_webhook_last_event_timestamp_seconds",
+			Help: "The timestamp of the last valid webhook event received",
+		}),
+	}
+	receiver.setSecret(secret)
+
+	go receiver.evict(ctx)
+
+	return receiver
+}
+
+// setSecret replaces the secret used to verify webhook signatures. An empty secret
+// disables the receiver.
+func (w *webhookReceiver) setSecret(secret string) {
+	w.secret.Store(new([]byte(secret)))
+}
+
+func (w *webhookReceiver) Describe(descriptorChannel chan<- *prometheus.Desc) {
+	w.runsFinished.Describe(descriptorChannel)
+	w.runQueueTime.Describe(descriptorChannel)
+	w.runExecutionTime.Describe(descriptorChannel)
+	w.rejectedWebhooks.Describe(descriptorChannel)
+	w.lastEventReceived.Describe(descriptorChannel)
+}
+
+func (w *webhookReceiver) Collect(metricChannel chan<- prometheus.Metric) {
+	w.runsFinished.Collect(metricChannel)
+	w.runQueueTime.Collect(metricChannel)
+	w.runExecutionTime.Collect(metricChannel)
+	w.rejectedWebhooks.Collect(metricChannel)
+	w.lastEventReceived.Collect(metricChannel)
+}
+
+func (w *webhookReceiver) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	secret := *w.secret.Load()
+	if len(secret) == 0 {
+		http.NotFound(rw, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, webhookMaxBodyBytes))
+	if err != nil {
+		w.reject(rw, "body", http.StatusBadRequest, err)
+		return
+	}
+
+	if !validSignature(secret, body, r.Header.Get(webhookSignatureHeader)) {
+		w.reject(rw, "signature", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var event runStateChangedEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		w.reject(rw, "payload", http.StatusBadRequest, err)
+		return
+	}
+
+	if event.Run.ID == "" || event.State == "" {
+		// Spacelift sends other kinds of events to the same webhook, which we
+		// acknowledge but otherwise ignore.
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.record(&event, time.Now())
+	w.lastEventReceived.SetToCurrentTime()
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (w *webhookReceiver) reject(rw http.ResponseWriter, reason string, status int, err error) {
+	w.rejectedWebhooks.WithLabelValues(reason).Inc()
+	w.logger.Warnw("Rejected webhook request", "reason", reason, zap.Error(err))
+	http.Error(rw, http.StatusText(status), status)
+}
+
+func validSignature(secret, body []byte, header string) bool {
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// record updates the run metrics based on a single state change. Spacelift doesn't
+// guarantee delivery order, so we take the earliest non-queued state we see as the
+// time the run started, and only observe the queue and execution times once the run
+// reaches a terminal state. Events for runs that already finished are dropped.
+func (w *webhookReceiver) record(event *runStateChangedEvent, now time.Time) {
+	transition := event.transition()
+	timestamp := time.Unix(int64(transition.Timestamp), 0)
+	runType := string(event.Run.Type)
+
+	w.runsMutex.Lock()
+	defer w.runsMutex.Unlock()
+
+	if _, ok := w.finishedRuns[event.Run.ID]; ok {
+		return
+	}
+
+	run, ok := w.runs[event.Run.ID]
+	if !ok {
+		run = &trackedRun{}
+		w.runs[event.Run.ID] = run
+	}
+	run.seenAt = now
+
+	if run.createdAt.IsZero() && event.Run.CreatedAt > 0 {
+		run.createdAt = time.Unix(int64(event.Run.CreatedAt), 0)
+	}
+
+	if !transition.State.IsQueued() && !transition.Terminal && (run.startedAt.IsZero() || timestamp.Before(run.startedAt)) {
+		run.startedAt = timestamp
+	}
+
+	if !transition.Terminal {
+		return
+	}
+
+	delete(w.runs, event.Run.ID)
+	w.finishedRuns[event.Run.ID] = now
+
+	w.runsFinished.WithLabelValues(event.Stack.ID, event.Stack.Name, runType, string(transition.State)).Inc()
+	if run.startedAt.IsZero() {
+		return
+	}
+
+	if !run.createdAt.IsZero() {
+		w.runQueueTime.WithLabelValues(event.Stack.ID, event.Stack.Name, runType).Observe(run.startedAt.Sub(run.createdAt).Seconds())
+	}
+	w.runExecutionTime.WithLabelValues(event.Stack.ID, event.Stack.Name, runType, string(transition.State)).Observe(timestamp.Sub(run.startedAt).Seconds())
+}
+
+// evict forgets stale runs every eviction interval until ctx is cancelled, rather
+// than scanning every tracked run on each event.
+func (w *webhookReceiver) evict(ctx context.Context) {
+	ticker := time.NewTicker(webhookEvictionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.evictStaleRuns(now)
+		}
+	}
+}
+
+func (w *webhookReceiver) evictStaleRuns(now time.Time) {
+	w.runsMutex.Lock()
+	defer w.runsMutex.Unlock()
+
+	for id, run := range w.runs {
+		if now.Sub(run.seenAt) > webhookRunTTL {
+			delete(w.runs, id)
+		}
+	}
+
+	for id, finishedAt := range w.finishedRuns {
+		if now.Sub(finishedAt) > webhookFinishedRunTTL {
+			delete(w.finishedRuns, id)
+		}
+	}
+}
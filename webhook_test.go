@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/exporter-toolkit/web"
+
+	"github.com/spacelift-io/prometheus-exporter/logging"
+)
+
+// webhookBasicAuthConfig enables basic auth for the user "spacelift" with the
+// password "fakepassword".
+const webhookBasicAuthConfig = `
+basic_auth_users:
+  spacelift: $2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi
+`
+
+func TestWebhookBehindBasicAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.yml")
+	if err := os.WriteFile(path, []byte(webhookBasicAuthConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	const secret = "secret"
+	receiver := newWebhookReceiver(logging.Init(context.Background(), true), secret)
+
+	mux := http.NewServeMux()
+	mux.Handle("/webhooks", receiver)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: mux}
+	t.Cleanup(func() { server.Close() })
+
+	go web.Serve(listener, server, &web.FlagConfig{WebConfigFile: &path}, slog.New(slog.DiscardHandler))
+
+	body := `{"state":"FINISHED","run":{"id":"run","type":"TRACKED"},"stack":{"id":"stack","name":"Stack"}}`
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	deliver := func(url string) int {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(webhookSignatureHeader, signature)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not deliver webhook: %v", err)
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		return resp.StatusCode
+	}
+
+	// Spacelift can only authenticate with credentials embedded in the webhook URL.
+	if status := deliver("http://" + listener.Addr().String() + "/webhooks"); status != http.StatusUnauthorized {
+		t.Errorf("got status %d for a webhook without credentials, want %d", status, http.StatusUnauthorized)
+	}
+
+	if status := deliver("http://spacelift:fakepassword@" + listener.Addr().String() + "/webhooks"); status != http.StatusNoContent {
+		t.Errorf("got status %d for a webhook with credentials in its URL, want %d", status, http.StatusNoContent)
+	}
+
+	if count := testutil.ToFloat64(receiver.runsFinished.WithLabelValues("stack", "Stack", "TRACKED", "FINISHED")); count != 1 {
+		t.Errorf("got %v finished runs, want 1", count)
+	}
+}